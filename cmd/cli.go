@@ -1,40 +1,84 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/spf13/cobra"
 	"k8sync/internal/config"
+	"k8sync/internal/gitops"
 	k8client "k8sync/internal/k8s/client"
 	"k8sync/internal/process"
+	"k8sync/internal/tracing"
 	"k8sync/pkg/logger"
 )
 
 func cliStart(cmd *cobra.Command, args []string) {
-	srcNamesapce := config.GetString("src.namespace")
+	settings := config.Current()
+	srcNamesapce := settings.Src.Namespace
 	if srcNamesapce == "" {
 		logger.Fatal("src.namespace is empty")
 	}
-	dstNamesapce := config.GetString("dst.namespace")
+	dstNamesapce := settings.Dst.Namespace
 	if dstNamesapce == "" {
 		dstNamesapce = srcNamesapce
 	}
-	srcK8 := k8client.New("src")
+	shutdown, err := tracing.Initialize(context.Background())
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer shutdown(context.Background())
+	// fail flushes the spans before exiting, logger.Fatal would drop them
+	fail := func(err error) {
+		logger.Error(err)
+		shutdown(context.Background())
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := process.SyncContext(ctx)
+	defer cancel()
+
+	srcK8, err := k8client.New("src")
+	if err != nil {
+		fail(err)
+	}
 	srcK8.SetNamespace(srcNamesapce)
 	logger.Infof("from src namespace: %s", srcNamesapce)
-	dstK8 := k8client.New("dst")
+	objs := settings.Src.Objects
+
+	if settings.Dst.Type == "git" {
+		repo, err := gitops.Open(settings.Dst.Git.Path, settings.Dst.Git.AuthorName, settings.Dst.Git.AuthorEmail)
+		if err != nil {
+			fail(err)
+		}
+		logger.Infof("to git repository: %s", repo.Path())
+		if err = process.ExportGit(ctx, srcK8, repo, objs); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	dstK8, err := k8client.New("dst")
+	if err != nil {
+		fail(err)
+	}
 	dstK8.SetNamespace(dstNamesapce)
 	logger.Infof("to  dest namespace: %s", dstNamesapce)
-	objs := config.GetStringSlice("src.objects")
-
-	for _, obj := range objs {
-		switch obj {
-		case "service":
-			if err := process.SyncService(srcK8, dstK8); err != nil {
-				logger.Fatal(err)
-			}
-		case "deployment":
-			if err := process.SyncDeployment(srcK8, dstK8); err != nil {
-				logger.Fatal(err)
-			}
-		}
+
+	summary := process.NewSummary()
+	err = process.SyncNamespace(ctx, srcK8, dstK8, objs, process.OptionsFromConfig(), summary)
+	fmt.Println()
+	summary.Print(os.Stdout)
+	if err != nil {
+		logger.Error(err)
+	}
+	if err != nil || len(summary.Failures()) > 0 {
+		shutdown(context.Background())
+		os.Exit(1)
 	}
 }
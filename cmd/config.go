@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"k8sync/internal/config"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "manage the settings file",
+	Long:  `manage the settings file`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "validate a settings file",
+	Long:  `validate checks yaml syntax, unknown keys, value types and rules of a settings file, the file of the run mode is used by default`,
+	Args:  cobra.MaximumNArgs(1),
+	Run:   configValidate,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func configValidate(cmd *cobra.Command, args []string) {
+	var filename string
+	if len(args) > 0 {
+		filename = args[0]
+	} else {
+		c, err := config.New(runMode)
+		if err == nil {
+			filename, err = c.GetFilename()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := config.ValidateFile(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err) // one error per line
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid\n", filename)
+}
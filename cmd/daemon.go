@@ -10,11 +10,14 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"k8sync/internal/auth"
 	"k8sync/internal/config"
 	"k8sync/internal/gateway"
 	"k8sync/internal/k8s/client"
 	"k8sync/internal/k8s/controller"
 	"k8sync/internal/k8s/handler"
+	"k8sync/internal/process"
+	"k8sync/internal/tracing"
 	log "k8sync/pkg/logger"
 )
 
@@ -28,22 +31,75 @@ func daemonStart(cmd *cobra.Command, args []string) {
 		log.Info("daemon stopped")
 	}()
 
-	if err = gateway.Start(ctx); err != nil {
+	shutdown, err := tracing.Initialize(ctx)
+	if err != nil {
 		log.Error(err)
 		return
 	}
-	log.Info("grpc svc started")
+	defer shutdown(context.Background())
+
+	svc := &gateway.Services{
+		Health: process.NewHealth(),
+		Backup: process.NewBackup(),
+		Events: process.NewEvents(handler.Events),
+	}
+	k8s, err := client.New("src")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	settings := config.Current()
+	if ns := settings.Src.Namespace; ns != "" {
+		k8s.SetNamespace(ns)
+	}
+	k8s.WatchCredentials(ctx)
+	svc.Health.AddCluster("src", k8s)
+	if svc.Auth, err = auth.NewFromConfig(k8s.Clientset); err != nil {
+		log.Error(err)
+		return
+	}
+	if settings.Dst.Type != "git" {
+		dstK8, err := client.New("dst")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		dstK8.WatchCredentials(ctx)
+		svc.Health.AddCluster("dst", dstK8)
+		svc.Sync = process.NewSync(ctx, k8s, dstK8)
+	}
+	runner := newControllerRunner(ctx, k8s, svc.Health)
+	if err = runner.Start(); err != nil {
+		log.Error(err)
+		return
+	}
+	defer runner.Stop()
+	if err = watchConfig(runner); err != nil {
+		log.Warnf("config hot reload disabled: %s", err)
+	}
 
-	var handler handler.Handler
-	if handler, err = prepareHandler(); err != nil {
+	if err = gateway.Start(ctx, svc); err != nil {
 		log.Error(err)
 		return
 	}
-	defer handler.Clean()
+	log.Info("grpc svc started")
 
-	k8s := client.New("src")
-	controller.Start(ctx, k8s, handler)
-	log.Info("k8s controller started")
+	if settings.Backup.Enabled {
+		go process.RunBackup(ctx, k8s, process.NewBackupStore(), settings.Src.Objects,
+			settings.Backup.Interval, settings.Backup.Keep, settings.Backup.MaxAge)
+		log.Info("backup scheduler started")
+	}
+	if settings.Policy.Enabled {
+		policies, err := controller.StartPolicies(ctx, k8s, settings.Policy.Namespace)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if svc.Sync != nil {
+			svc.Sync.SetPolicies(policies)
+		}
+		log.Info("syncpolicy controller started")
+	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -51,9 +107,16 @@ func daemonStart(cmd *cobra.Command, args []string) {
 }
 
 func prepareHandler() (handler.Handler, error) {
-	handler := new(handler.Default)
-	if err := handler.Init(config.Curr()); err != nil {
-		return nil, fmt.Errorf("init handler failed: %w", err)
+	name := config.Current().Handler.Name
+	if name == "" {
+		name = "default"
+	}
+	h, ok := handler.Map[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown handler: %s", name)
+	}
+	if err := h.Init(config.Curr()); err != nil {
+		return nil, fmt.Errorf("init handler %s failed: %w", name, err)
 	}
-	return handler, nil
+	return h, nil
 }
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"k8sync/internal/config"
+	"k8sync/internal/k8s/client"
+	"k8sync/internal/k8s/controller"
+	"k8sync/internal/k8s/handler"
+	"k8sync/internal/k8s/utils"
+	"k8sync/internal/process"
+	log "k8sync/pkg/logger"
+)
+
+// controllerStopTimeout bounds the wait for the old controller on restart
+const controllerStopTimeout = 30 * time.Second
+
+// controllerRunner runs the controller with its handler,
+// and restarts both when the namespace or handler settings change
+type controllerRunner struct {
+	ctx    context.Context
+	k8s    *client.K8s
+	health *process.Health
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	ctl      *controller.Controller
+	handler  handler.Handler
+	settings string // settings the running controller was started with
+}
+
+func newControllerRunner(ctx context.Context, k8s *client.K8s, health *process.Health) *controllerRunner {
+	return &controllerRunner{ctx: ctx, k8s: k8s, health: health}
+}
+
+// controllerSettings are the settings which need a controller restart when changed
+func controllerSettings() string {
+	s := config.Current()
+	return fmt.Sprint(s.Src.Namespace, s.Handler.Name, s.Dst.Git)
+}
+
+// Start starts the controller with the current settings
+func (r *controllerRunner) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.start()
+}
+
+func (r *controllerRunner) start() error {
+	eventHandler, err := prepareHandler()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	k8s := r.k8s
+	if ns := config.Current().Src.Namespace; ns != "" {
+		k8s = k8s.WithNamespace(ns)
+	}
+	r.cancel, r.handler, r.settings = cancel, eventHandler, controllerSettings()
+	if r.ctl = controller.Start(ctx, k8s, eventHandler); r.ctl != nil {
+		r.health.SetWatcher(r.ctl)
+	}
+	log.Infof("k8s controller started in namespace %s with %s handler", k8s.GetNamespace(), config.Current().Handler.Name)
+	return nil
+}
+
+// Stop stops the controller and cleans its handler
+func (r *controllerRunner) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stop()
+}
+
+func (r *controllerRunner) stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	if r.ctl != nil {
+		select {
+		case <-r.ctl.Done():
+		case <-time.After(controllerStopTimeout):
+			log.Warnf("k8s controller did not stop in %s", controllerStopTimeout)
+		}
+	}
+	r.handler.Clean()
+	r.cancel, r.ctl, r.handler = nil, nil, nil
+}
+
+// Reload restarts the controller when its settings changed
+func (r *controllerRunner) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil && controllerSettings() == r.settings {
+		return
+	}
+	log.Info("controller settings changed, restart k8s controller")
+	r.stop()
+	if err := r.start(); err != nil {
+		log.Errorf("restart k8s controller failed: %s", err)
+	}
+}
+
+func init() {
+	config.AddValidator("src.objects", func(s *config.Settings) error {
+		for _, kind := range s.Src.Objects {
+			if !contains(process.ExportKinds, kind) {
+				return fmt.Errorf("unsupported object kind %q", kind)
+			}
+		}
+		return nil
+	})
+	config.AddValidator("handler.name", func(s *config.Settings) error {
+		if name := s.Handler.Name; name != "" {
+			if _, ok := handler.Map[name]; !ok {
+				return fmt.Errorf("unknown handler %q", name)
+			}
+		}
+		return nil
+	})
+}
+
+// watchConfig reloads the log level and the controller when the config file changes,
+// an invalid config is not applied and published as an event
+func watchConfig(runner *controllerRunner) error {
+	// the destination clients are created at start
+	dstType := config.Current().Dst.Type
+	config.AddValidator("dst.type", func(s *config.Settings) error {
+		if s.Dst.Type != dstType {
+			return fmt.Errorf("can not change from %q to %q without restart", dstType, s.Dst.Type)
+		}
+		return nil
+	})
+	config.OnReload(func() {
+		log.SetLevel(config.Current().Log.Level)
+		log.Infof("config reloaded from %s", viper.ConfigFileUsed())
+	})
+	config.OnReload(runner.Reload)
+	return config.Watch(func(err error) {
+		log.Errorf("reload config failed: %s", err)
+		handler.Events.Publish(&handler.Event{
+			Kind:   "config",
+			Name:   viper.ConfigFileUsed(),
+			Reason: "rejected",
+			Status: utils.StatusDanger,
+			Time:   time.Now(),
+			Obj:    err.Error(),
+		})
+	})
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/internal/process"
+	"k8sync/pkg/logger"
+)
+
+var (
+	snapshotID    string
+	listSnapshots bool
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "restore a namespace snapshot",
+	Long:  `restore replays a namespace snapshot saved by the daemon backup into the destination cluster`,
+	Run:   restoreStart,
+}
+
+func init() {
+	restoreCmd.Flags().StringVarP(&snapshotID, "snapshot", "s", "", "snapshot id to restore")
+	restoreCmd.Flags().BoolVarP(&listSnapshots, "list", "l", false, "list snapshots")
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func restoreStart(cmd *cobra.Command, args []string) {
+	checkConfig()
+	store := process.NewBackupStore()
+	if listSnapshots {
+		snaps, err := store.List()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		for _, snap := range snaps {
+			fmt.Printf("%-24s %-20s %-30s %d\n", snap.ID, snap.Namespace, snap.Created.Local(), snap.Objects)
+		}
+		return
+	}
+	if snapshotID == "" {
+		logger.Fatal("snapshot id is empty")
+	}
+
+	snap, _, err := store.Load(snapshotID)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	dstNamesapce := config.Current().Dst.Namespace
+	if dstNamesapce == "" {
+		dstNamesapce = snap.Namespace
+	}
+	dstK8, err := k8client.New("dst")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	dstK8.SetNamespace(dstNamesapce)
+	logger.Infof("to  dest namespace: %s", dstNamesapce)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := process.SyncContext(ctx)
+	defer cancel()
+	summary := process.NewSummary()
+	err = process.Restore(ctx, store, snapshotID, dstK8, config.Current().Src.Objects, summary)
+	fmt.Println()
+	summary.Print(os.Stdout)
+	if err != nil {
+		logger.Error(err)
+	}
+	if err != nil || len(summary.Failures()) > 0 {
+		os.Exit(1)
+	}
+}
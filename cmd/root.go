@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	log "k8sync/pkg/logger"
 
@@ -16,6 +17,10 @@ var (
 	cfg     *config.Config
 	Verbose bool
 	daemon  bool
+
+	// configErr is set when the config file can not be read or decoded,
+	// it is reported by commands which need a valid config
+	configErr error
 )
 
 var rootCmd = &cobra.Command{
@@ -23,7 +28,8 @@ var rootCmd = &cobra.Command{
 	Short: "k8sync is a tool for syncing kubernetes resources",
 	Long:  `k8sync can sync kubernetes resources from one cluster to another`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if config.GetBool("daemon") {
+		checkConfig()
+		if config.Current().Daemon {
 			log.Info("Starting k8sync in daemon mode")
 			daemonStart(cmd, args)
 		} else {
@@ -49,38 +55,72 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&daemon, "daemon", "d", false, "run as daemon")
 	rootCmd.PersistentFlags().BoolP("yaml", "y", false, "export source cluster yaml")
 	rootCmd.PersistentFlags().StringP("src-kube-config", "", "", "source kube config file")
+	rootCmd.PersistentFlags().StringP("src-context", "", "", "source kube config context")
 	rootCmd.PersistentFlags().StringP("src-namespace", "n", "", "source k8s namespace")
 	rootCmd.PersistentFlags().StringArrayP("src-objects", "o", []string{"deployment", "service"}, "k8s object to sync")
 	rootCmd.PersistentFlags().StringP("dst-kube-config", "c", "", "destination kube config file")
+	rootCmd.PersistentFlags().StringP("dst-context", "", "", "destination kube config context")
 	rootCmd.PersistentFlags().StringP("dst-namespace", "", "", "destination k8s namespace")
-	rootCmd.PersistentFlags().StringP("include", "i", "", "include object by name")
-	rootCmd.PersistentFlags().StringP("exclude", "e", "", "exclude object by name")
-	if err := viper.BindPFlag("app.yaml", rootCmd.PersistentFlags().Lookup("yaml")); err != nil {
-		log.Fatal(err)
-	}
-	if err := viper.BindPFlag("src.kube-config", rootCmd.PersistentFlags().Lookup("src-kube-config")); err != nil {
-		log.Fatal(err)
-	}
-	if err := viper.BindPFlag("src.namespace", rootCmd.PersistentFlags().Lookup("src-namespace")); err != nil {
-		log.Fatal(err)
+	rootCmd.PersistentFlags().StringP("dst-type", "", "cluster", "destination type with: cluster, git")
+	rootCmd.PersistentFlags().StringP("dst-git-path", "", "", "destination git working tree path")
+	rootCmd.PersistentFlags().StringSliceP("include", "i", nil, "include object by name")
+	rootCmd.PersistentFlags().StringSliceP("exclude", "e", nil, "exclude object by name")
+	rootCmd.PersistentFlags().BoolP("dependencies", "", false, "also sync the objects synced deployments reference")
+}
+
+// flagKeys are the settings keys set by the persistent flags
+var flagKeys = []struct{ key, flag string }{
+	{"app.yaml", "yaml"},
+	{"src.kube-config", "src-kube-config"},
+	{"src.context", "src-context"},
+	{"src.namespace", "src-namespace"},
+	{"src.objects", "src-objects"},
+	{"dst.kube-config", "dst-kube-config"},
+	{"dst.type", "dst-type"},
+	{"dst.git.path", "dst-git-path"},
+	{"dst.context", "dst-context"},
+	{"dst.namespace", "dst-namespace"},
+	{"src.include", "include"},
+	{"src.exclude", "exclude"},
+	{"src.dependencies", "dependencies"},
+	{"daemon", "daemon"},
+}
+
+// overlay applies the flags and the environment to v, the global viper or the one a config reload is validated on
+func overlay(v *viper.Viper) error {
+	for _, f := range flagKeys {
+		if err := v.BindPFlag(f.key, rootCmd.PersistentFlags().Lookup(f.flag)); err != nil {
+			return err
+		}
 	}
-	if err := viper.BindPFlag("src.objects", rootCmd.PersistentFlags().Lookup("src-objects")); err != nil {
-		log.Fatal(err)
+	if cfg != nil {
+		v.SetEnvPrefix(cfg.GetEnvPrefix())
 	}
-	if err := viper.BindPFlag("dst.kube-config", rootCmd.PersistentFlags().Lookup("dst-kube-config")); err != nil {
-		log.Fatal(err)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+	return nil
+}
+
+// checkConfig exits when the config can not be loaded or is invalid, the config file is checked
+// like config validate does, unknown keys included, then the settings with flags and environment
+func checkConfig() {
+	if configErr != nil {
+		log.Fatalf("load config failed: %s", configErr)
 	}
-	if err := viper.BindPFlag("dst.namespace", rootCmd.PersistentFlags().Lookup("include")); err != nil {
-		log.Fatal(err)
+	if filename := viper.ConfigFileUsed(); filename != "" {
+		if err := config.ValidateFile(filename); err != nil {
+			log.Fatalf("invalid config file:\n%s", err)
+		}
 	}
-	if err := viper.BindPFlag("src.include", rootCmd.PersistentFlags().Lookup("exclude")); err != nil {
-		log.Fatal(err)
+	if err := config.Current().Validate(); err != nil {
+		log.Fatalf("invalid config:\n%s", err)
 	}
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	var err error
+	config.SetDefaults(viper.GetViper())
 	if runMode == "cli" {
 		viper.SetDefault("log.consoleStdout", true)
 	} else {
@@ -89,18 +129,24 @@ func initConfig() {
 			fmt.Printf("FATAL with mode %s: %s\n", runMode, err)
 			os.Exit(1)
 		}
-		cfg.CheckMissingResourceEnvvars()
-		viper.SetConfigFile(cfg.GetFilename())
+		filename, _ := cfg.GetFilename()
+		viper.SetConfigFile(filename)
 		//viper.AddConfigPath(cfg.GetPath())
 		//viper.SetConfigType(cfg.GetFileType())
 		//viper.SetConfigName(cfg.GetFileBasename())
-		viper.SetEnvPrefix(cfg.GetEnvPrefix())
 	}
-	viper.AutomaticEnv()
+	if err = config.SetOverlay(overlay); err != nil {
+		fmt.Printf("FATAL bind flags: %s\n", err)
+		os.Exit(1)
+	}
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	if viper.ConfigFileUsed() != "" {
+		if configErr = viper.ReadInConfig(); configErr == nil {
+			fmt.Println("Using config file:", viper.ConfigFileUsed())
+		}
+	}
+	if configErr == nil {
+		configErr = config.Refresh()
 	}
-	log.Initialize()
+	log.Initialize(config.Current().Log)
 }
@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+
+	"k8sync/gen/proto/k8sync/v1"
+	"k8sync/internal/config"
+	"k8sync/pkg/logger"
+)
+
+// Role grants access to a set of rpcs, a role includes all lower roles
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// ParseRole converts a configured role name into its role
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(s) {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleNone, fmt.Errorf("unknown role: %q", s)
+}
+
+// methodRoles is the role required by each rpc, rpcs not listed require admin
+var methodRoles = map[string]Role{
+	healthpb.Health_Check_FullMethodName:     RoleNone,
+	healthpb.Health_Watch_FullMethodName:     RoleNone,
+	pb.HealthService_IsHealth_FullMethodName: RoleViewer,
+
+	pb.BackupService_ListSnapshots_FullMethodName: RoleViewer,
+	pb.EventService_WatchEvents_FullMethodName:    RoleViewer,
+
+	pb.SyncService_ListRuns_FullMethodName:    RoleViewer,
+	pb.SyncService_GetRun_FullMethodName:      RoleViewer,
+	pb.SyncService_WatchRun_FullMethodName:    RoleViewer,
+	pb.SyncService_TriggerSync_FullMethodName: RoleOperator,
+	pb.SyncService_CancelRun_FullMethodName:   RoleOperator,
+}
+
+// RequiredRole returns the role needed to call the full rpc method name
+func RequiredRole(method string) Role {
+	if role, ok := methodRoles[method]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+// Identity is an authenticated caller
+type Identity struct {
+	Name   string
+	Role   Role
+	Method string // authenticator which identified the caller
+}
+
+func (id *Identity) String() string {
+	return fmt.Sprintf("%s(%s via %s)", id.Name, id.Role, id.Method)
+}
+
+// Request holds the credentials presented by a caller
+type Request struct {
+	Token string              // bearer token
+	Certs []*x509.Certificate // verified client certificate chain, leaf first
+}
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	// Authenticate returns nil without error when the request carries no
+	// credential this authenticator understands
+	Authenticate(ctx context.Context, req *Request) (*Identity, error)
+}
+
+var errNoCredentials = errors.New("no valid credentials")
+
+// metadata set by the gateway to forward the identity of http callers
+const (
+	gatewayKeyHeader    = "x-k8sync-gateway-key"
+	gatewayUserHeader   = "x-k8sync-user"
+	gatewayRoleHeader   = "x-k8sync-role"
+	gatewayMethodHeader = "x-k8sync-auth-method"
+	// http headers forwarded by grpc-gateway as grpc metadata
+	forwardedHeaderPrefix = "Grpc-Metadata-X-K8sync-"
+)
+
+// Authorizer authenticates callers with a chain of authenticators,
+// the first identity found wins, and checks the role required by each rpc
+type Authorizer struct {
+	authenticators []Authenticator
+	// random key proving forwarded identities come from the in-process gateway
+	gatewayKey string
+}
+
+// NewAuthorizer creates an authorizer trying the authenticators in order
+func NewAuthorizer(authenticators ...Authenticator) (*Authorizer, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Authorizer{authenticators: authenticators, gatewayKey: hex.EncodeToString(b)}, nil
+}
+
+// NewFromConfig creates the authorizer configured by auth.*,
+// client is used for TokenReview. It returns nil when auth.enabled is false.
+func NewFromConfig(client kubernetes.Interface) (*Authorizer, error) {
+	c := config.Current().Auth
+	if !c.Enabled {
+		return nil, nil
+	}
+	var authenticators []Authenticator
+	if len(c.Tokens) > 0 {
+		a, err := NewStaticTokens(c.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if c.MTLS.Enabled {
+		a, err := NewCertAuth(c.MTLS.Subjects, c.MTLS.DefaultRole)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if c.TokenReview.Enabled {
+		a, err := NewTokenReview(client, c.TokenReview.Audiences, c.TokenReview.Subjects, c.TokenReview.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if len(authenticators) == 0 {
+		return nil, errors.New("auth is enabled but no authenticator is configured")
+	}
+	return NewAuthorizer(authenticators...)
+}
+
+func init() {
+	config.AddValidator("auth", validateSettings)
+}
+
+// validateSettings checks the roles and credentials of the auth section
+func validateSettings(s *config.Settings) error {
+	var errs []error
+	c := s.Auth
+	checkRole := func(key, role string) {
+		if _, err := ParseRole(role); err != nil {
+			errs = append(errs, &config.FieldError{Key: key, Msg: err.Error()})
+		}
+	}
+	for i, t := range c.Tokens {
+		key := fmt.Sprintf("auth.tokens[%d]", i)
+		checkRole(key+".role", t.Role)
+		if t.Token == "" && t.TokenFile == "" {
+			errs = append(errs, &config.FieldError{Key: key, Msg: "token or token-file is required"})
+		}
+	}
+	checkSubjects := func(key string, subjects []config.SubjectSettings) {
+		for i, sub := range subjects {
+			checkRole(fmt.Sprintf("%s[%d].role", key, i), sub.Role)
+			if sub.Name == "" && sub.Group == "" {
+				errs = append(errs, &config.FieldError{Key: fmt.Sprintf("%s[%d]", key, i), Msg: "name or group is required"})
+			}
+		}
+	}
+	checkSubjects("auth.mtls.subjects", c.MTLS.Subjects)
+	checkSubjects("auth.token-review.subjects", c.TokenReview.Subjects)
+	if c.MTLS.DefaultRole != "" {
+		checkRole("auth.mtls.default-role", c.MTLS.DefaultRole)
+	}
+	if c.Enabled && len(c.Tokens) == 0 && !c.MTLS.Enabled && !c.TokenReview.Enabled {
+		errs = append(errs, &config.FieldError{Key: "auth.enabled", Msg: "no authenticator is configured"})
+	}
+	return errors.Join(errs...)
+}
+
+// Authenticate runs the authenticators in order
+func (a *Authorizer) Authenticate(ctx context.Context, req *Request) (*Identity, error) {
+	if req.Token == "" && len(req.Certs) == 0 {
+		return nil, errNoCredentials
+	}
+	for _, authenticator := range a.authenticators {
+		id, err := authenticator.Authenticate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if id != nil {
+			return id, nil
+		}
+	}
+	return nil, errNoCredentials
+}
+
+// authorize checks the caller of method in ctx, the identity is added to the returned context
+func (a *Authorizer) authorize(ctx context.Context, method string) (context.Context, error) {
+	role := RequiredRole(method)
+	if role == RoleNone {
+		return ctx, nil
+	}
+	id, err := a.identify(ctx)
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if id.Role < role {
+		logger.Warnf("permission denied: %s calls %s which requires %s", id, method, role)
+		return ctx, status.Errorf(codes.PermissionDenied, "%s requires role %s", method, role)
+	}
+	return NewContext(ctx, id), nil
+}
+
+// identify returns the identity forwarded by the gateway, or authenticates
+// the bearer token and the client certificate of a direct grpc call
+func (a *Authorizer) identify(ctx context.Context) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(gatewayKeyHeader); len(keys) > 0 {
+		if len(keys) != 1 || subtle.ConstantTimeCompare([]byte(keys[0]), []byte(a.gatewayKey)) != 1 {
+			return nil, errors.New("invalid gateway key")
+		}
+		users, roles, methods := md.Get(gatewayUserHeader), md.Get(gatewayRoleHeader), md.Get(gatewayMethodHeader)
+		if len(users) != 1 || len(roles) != 1 || len(methods) != 1 {
+			return nil, errNoCredentials
+		}
+		role, err := ParseRole(roles[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Identity{Name: users[0], Role: role, Method: methods[0]}, nil
+	}
+
+	req := &Request{}
+	if values := md.Get("authorization"); len(values) > 0 {
+		req.Token = bearerToken(values[0])
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			req.Certs = info.State.VerifiedChains[0]
+		}
+	}
+	return a.Authenticate(ctx, req)
+}
+
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// UnaryServerInterceptor enforces the role of each unary rpc
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor enforces the role of each streaming rpc
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// identityStream carries the authorized context into stream handlers
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// AuthenticateHTTP authenticates an http request of the gateway. Forged forwarding
+// headers are dropped, the identity is added to the request context when found.
+func (a *Authorizer) AuthenticateHTTP(r *http.Request) *http.Request {
+	for key := range r.Header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(key), forwardedHeaderPrefix) {
+			r.Header.Del(key)
+		}
+	}
+	req := &Request{Token: bearerToken(r.Header.Get("Authorization"))}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		req.Certs = r.TLS.VerifiedChains[0]
+	}
+	id, err := a.Authenticate(r.Context(), req)
+	if err != nil {
+		if !errors.Is(err, errNoCredentials) {
+			logger.Warnf("authenticate %s %s failed: %s", r.Method, r.URL.Path, err)
+		}
+		return r
+	}
+	return r.WithContext(NewContext(r.Context(), id))
+}
+
+// AuthorizeHTTP checks the identity added by AuthenticateHTTP has role,
+// it writes the error response and returns false otherwise
+func (a *Authorizer) AuthorizeHTTP(w http.ResponseWriter, r *http.Request, role Role) bool {
+	id := FromContext(r.Context())
+	if id == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, errNoCredentials.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if id.Role < role {
+		http.Error(w, fmt.Sprintf("%s requires role %s", r.URL.Path, role), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// GatewayMetadata forwards the identity of an http request to the grpc server,
+// it is meant for runtime.WithMetadata of the gateway mux
+func (a *Authorizer) GatewayMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	md := metadata.Pairs(gatewayKeyHeader, a.gatewayKey)
+	if id := FromContext(r.Context()); id != nil {
+		md.Set(gatewayUserHeader, id.Name)
+		md.Set(gatewayRoleHeader, id.Role.String())
+		md.Set(gatewayMethodHeader, id.Method)
+	}
+	return md
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the caller, nil when auth is disabled
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// Caller names the caller in ctx for logs
+func Caller(ctx context.Context) string {
+	if id := FromContext(ctx); id != nil {
+		return id.Name
+	}
+	return "anonymous"
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8sync/internal/config"
+)
+
+const defaultTokenReviewTTL = time.Minute
+
+type subjectRole struct {
+	name  string
+	group string
+	role  Role
+}
+
+func parseSubjects(subjects []config.SubjectSettings) ([]subjectRole, error) {
+	var roles []subjectRole
+	for _, s := range subjects {
+		if s.Name == "" && s.Group == "" {
+			return nil, errors.New("auth subject needs a name or a group")
+		}
+		role, err := ParseRole(s.Role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, subjectRole{name: s.Name, group: s.Group, role: role})
+	}
+	return roles, nil
+}
+
+// roleOf returns the highest role granted to name or any of groups
+func roleOf(subjects []subjectRole, name string, groups []string) Role {
+	role := RoleNone
+	for _, s := range subjects {
+		if s.role <= role {
+			continue
+		}
+		if (s.name != "" && s.name == name) || (s.group != "" && contains(groups, s.group)) {
+			role = s.role
+		}
+	}
+	return role
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticTokens authenticates configured bearer tokens
+type StaticTokens struct {
+	tokens []staticToken
+}
+
+type staticToken struct {
+	token []byte
+	id    *Identity
+}
+
+// NewStaticTokens loads the tokens, a token file is read once at start
+func NewStaticTokens(tokens []config.TokenSettings) (*StaticTokens, error) {
+	a := &StaticTokens{}
+	for _, t := range tokens {
+		role, err := ParseRole(t.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %w", t.Name, err)
+		}
+		token := t.Token
+		if t.TokenFile != "" {
+			b, err := os.ReadFile(t.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("token %s: %w", t.Name, err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("token %s is empty", t.Name)
+		}
+		a.tokens = append(a.tokens, staticToken{
+			token: []byte(token),
+			id:    &Identity{Name: t.Name, Role: role, Method: "token"},
+		})
+	}
+	return a, nil
+}
+
+func (a *StaticTokens) Authenticate(ctx context.Context, req *Request) (*Identity, error) {
+	if req.Token == "" {
+		return nil, nil
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(t.token, []byte(req.Token)) == 1 {
+			return t.id, nil
+		}
+	}
+	return nil, nil
+}
+
+// CertAuth authenticates verified client certificates, the common name is the
+// user name and the organizations are the groups, like the kubernetes api server
+type CertAuth struct {
+	subjects    []subjectRole
+	defaultRole Role
+}
+
+// NewCertAuth creates a client certificate authenticator, defaultRole is
+// granted to certificates matching no subject and may be empty to reject them
+func NewCertAuth(subjects []config.SubjectSettings, defaultRole string) (*CertAuth, error) {
+	roles, err := parseSubjects(subjects)
+	if err != nil {
+		return nil, err
+	}
+	a := &CertAuth{subjects: roles}
+	if defaultRole != "" {
+		if a.defaultRole, err = ParseRole(defaultRole); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *CertAuth) Authenticate(ctx context.Context, req *Request) (*Identity, error) {
+	if len(req.Certs) == 0 {
+		return nil, nil
+	}
+	return a.identity(req.Certs[0])
+}
+
+func (a *CertAuth) identity(cert *x509.Certificate) (*Identity, error) {
+	name := cert.Subject.CommonName
+	role := roleOf(a.subjects, name, cert.Subject.Organization)
+	if role == RoleNone {
+		role = a.defaultRole
+	}
+	if role == RoleNone {
+		return nil, fmt.Errorf("certificate %s has no role", name)
+	}
+	return &Identity{Name: name, Role: role, Method: "mtls"}, nil
+}
+
+// TokenReview authenticates bearer tokens with the TokenReview api of a cluster,
+// results are cached for ttl so a busy client does not flood the api server
+type TokenReview struct {
+	client    kubernetes.Interface
+	audiences []string
+	subjects  []subjectRole
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]reviewResult
+}
+
+type reviewResult struct {
+	id      *Identity
+	err     error
+	expires time.Time
+}
+
+// NewTokenReview creates a TokenReview authenticator,
+// subjects map the reviewed user name and groups to roles
+func NewTokenReview(client kubernetes.Interface, audiences []string, subjects []config.SubjectSettings, ttl time.Duration) (*TokenReview, error) {
+	if client == nil {
+		return nil, errors.New("token review needs a cluster client")
+	}
+	roles, err := parseSubjects(subjects)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = defaultTokenReviewTTL
+	}
+	return &TokenReview{
+		client:    client,
+		audiences: audiences,
+		subjects:  roles,
+		ttl:       ttl,
+		cache:     make(map[[sha256.Size]byte]reviewResult),
+	}, nil
+}
+
+func (a *TokenReview) Authenticate(ctx context.Context, req *Request) (*Identity, error) {
+	if req.Token == "" {
+		return nil, nil
+	}
+	key := sha256.Sum256([]byte(req.Token))
+	now := time.Now()
+	a.mu.Lock()
+	res, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && now.Before(res.expires) {
+		return res.id, res.err
+	}
+
+	id, final, err := a.review(ctx, req.Token)
+	if !final {
+		// api errors are not cached, the next request retries
+		return id, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, r := range a.cache {
+		if now.After(r.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = reviewResult{id: id, err: err, expires: now.Add(a.ttl)}
+	return id, err
+}
+
+// review asks the api server about token, final is false when the review
+// itself failed and the result must not be cached
+func (a *TokenReview) review(ctx context.Context, token string) (id *Identity, final bool, err error) {
+	tr, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token, Audiences: a.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("token review failed: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		// not a kubernetes token
+		return nil, true, nil
+	}
+	user := tr.Status.User
+	role := roleOf(a.subjects, user.Username, user.Groups)
+	if role == RoleNone {
+		return nil, true, fmt.Errorf("user %s has no role", user.Username)
+	}
+	return &Identity{Name: user.Username, Role: role, Method: "token-review"}, true, nil
+}
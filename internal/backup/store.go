@@ -0,0 +1,321 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	metaFile   = "snapshot.json"
+	archiveExt = ".tar.gz"
+	idLayout   = "20060102T150405Z"
+)
+
+// Snapshot describes one saved namespace snapshot
+type Snapshot struct {
+	ID         string    `json:"id"`
+	Namespace  string    `json:"namespace"`
+	Created    time.Time `json:"created"`
+	Objects    int       `json:"objects"`
+	Compressed bool      `json:"compressed"`
+}
+
+// Store keeps snapshots in a local directory, each snapshot is either
+// a sub directory or a gzip compressed tar archive named by its id
+type Store struct {
+	path     string
+	compress bool
+}
+
+// NewStore creates a snapshot store rooted at path
+func NewStore(path string, compress bool) *Store {
+	return &Store{path: path, compress: compress}
+}
+
+// Save writes a new snapshot of namespace ns,
+// files maps the relative manifest path (<kind>/<name>.yaml) to its content
+func (s *Store) Save(ns string, files map[string][]byte) (*Snapshot, error) {
+	if err := os.MkdirAll(s.path, 0750); err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{
+		Namespace:  ns,
+		Created:    time.Now().UTC(),
+		Objects:    len(files),
+		Compressed: s.compress,
+	}
+	snap.ID = s.newID(snap.Created)
+	meta, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	if s.compress {
+		err = s.saveArchive(snap.ID, meta, files)
+	} else {
+		err = s.saveDir(snap.ID, meta, files)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("save snapshot %s failed: %w", snap.ID, err)
+	}
+	return snap, nil
+}
+
+// List returns all snapshots, newest first
+func (s *Store) List() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snaps []*Snapshot
+	for _, e := range entries {
+		id, ok := snapshotID(e)
+		if !ok {
+			continue
+		}
+		snap, _, err := s.load(id, true)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Created.After(snaps[j].Created)
+	})
+	return snaps, nil
+}
+
+// Load reads the snapshot and all its manifests
+func (s *Store) Load(id string) (*Snapshot, map[string][]byte, error) {
+	return s.load(id, false)
+}
+
+// Prune removes snapshots beyond the newest keep ones or older than maxAge,
+// zero value disables the corresponding rule. It returns the removed ids.
+func (s *Store) Prune(keep int, maxAge time.Duration) ([]string, error) {
+	snaps, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i, snap := range snaps {
+		expired := maxAge > 0 && time.Since(snap.Created) > maxAge
+		if !expired && (keep <= 0 || i < keep) {
+			continue
+		}
+		if err = s.remove(snap); err != nil {
+			return removed, err
+		}
+		removed = append(removed, snap.ID)
+	}
+	return removed, nil
+}
+
+func (s *Store) newID(t time.Time) string {
+	id := t.Format(idLayout)
+	for i := 1; s.exists(id); i++ {
+		id = fmt.Sprintf("%s-%d", t.Format(idLayout), i)
+	}
+	return id
+}
+
+func (s *Store) exists(id string) bool {
+	if _, err := os.Stat(filepath.Join(s.path, id)); err == nil {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(s.path, id+archiveExt))
+	return err == nil
+}
+
+func (s *Store) remove(snap *Snapshot) error {
+	if snap.Compressed {
+		return os.Remove(filepath.Join(s.path, snap.ID+archiveExt))
+	}
+	return os.RemoveAll(filepath.Join(s.path, snap.ID))
+}
+
+func (s *Store) saveDir(id string, meta []byte, files map[string][]byte) error {
+	dir := filepath.Join(s.path, id)
+	tmp := dir + ".tmp"
+	for name, data := range files {
+		file := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, data, 0640); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(tmp, 0750); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, metaFile), meta, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+func (s *Store) saveArchive(id string, meta []byte, files map[string][]byte) error {
+	file := filepath.Join(s.path, id+archiveExt)
+	f, err := os.OpenFile(file+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	// metadata goes first, so listing does not need to read the whole archive
+	if err = writeTarFile(tw, metaFile, meta); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = writeTarFile(tw, name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func (s *Store) load(id string, metaOnly bool) (*Snapshot, map[string][]byte, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, nil, fmt.Errorf("invalid snapshot id: %q", id)
+	}
+	var meta []byte
+	var files map[string][]byte
+	var err error
+	if _, statErr := os.Stat(filepath.Join(s.path, id+archiveExt)); statErr == nil {
+		meta, files, err = loadArchive(filepath.Join(s.path, id+archiveExt), metaOnly)
+	} else if _, statErr = os.Stat(filepath.Join(s.path, id)); statErr == nil {
+		meta, files, err = loadDir(filepath.Join(s.path, id), metaOnly)
+	} else {
+		return nil, nil, fmt.Errorf("snapshot %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("load snapshot %s failed: %w", id, err)
+	}
+	if meta == nil {
+		return nil, nil, fmt.Errorf("snapshot %s has no %s", id, metaFile)
+	}
+	snap := &Snapshot{}
+	if err = json.Unmarshal(meta, snap); err != nil {
+		return nil, nil, fmt.Errorf("decode snapshot %s failed: %w", id, err)
+	}
+	return snap, files, nil
+}
+
+func loadDir(dir string, metaOnly bool) ([]byte, map[string][]byte, error) {
+	meta, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if err != nil || metaOnly {
+		return meta, nil, err
+	}
+	files := make(map[string][]byte)
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == metaFile {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	return meta, files, err
+}
+
+func loadArchive(file string, metaOnly bool) ([]byte, map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gr.Close()
+
+	var meta []byte
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == metaFile {
+			meta = data
+			if metaOnly {
+				return meta, nil, nil
+			}
+			continue
+		}
+		files[hdr.Name] = data
+	}
+	return meta, files, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0640,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func snapshotID(e os.DirEntry) (string, bool) {
+	name := e.Name()
+	if strings.HasSuffix(name, ".tmp") {
+		return "", false
+	}
+	if e.IsDir() {
+		return name, true
+	}
+	if strings.HasSuffix(name, archiveExt) {
+		return strings.TrimSuffix(name, archiveExt), true
+	}
+	return "", false
+}
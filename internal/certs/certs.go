@@ -0,0 +1,244 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8sync/internal/config"
+	"k8sync/pkg/logger"
+)
+
+const (
+	ClientAuthNone          = "none"
+	ClientAuthRequest       = "request"
+	ClientAuthVerifyIfGiven = "verify-if-given"
+	ClientAuthRequire       = "require"
+
+	defaultReloadInterval = 30 * time.Second
+)
+
+// Reloader holds a certificate and an optional CA bundle loaded from disk,
+// both are reloaded when the files change so rotated certificates are picked
+// up without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewReloader loads the key pair and the CA bundle, caFile may be empty
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls cert-file and key-file are required")
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reloads the files when any of them changed,
+// the previous certificate is kept when loading fails
+func (r *Reloader) Reload() error {
+	if !r.changed() {
+		return nil
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	logger.Infof("tls certificate %s reloaded", r.certFile)
+	return nil
+}
+
+// Watch reloads the files every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				logger.Errorf("reload tls certificate failed: %s", err)
+			}
+		}
+	}
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			// the file may be replaced right now, try again next time
+			return false
+		}
+		if !fi.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = fi.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls key pair %s failed: %w", r.certFile, err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		b, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+// Certificate returns the current key pair
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// CertPool returns the current CA bundle, nil when no CA file is set
+func (r *Reloader) CertPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// ServerConfig returns a server tls config, client certificates are
+// verified against the CA bundle according to clientAuth
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.Certificate()},
+				ClientAuth:   clientAuth,
+				ClientCAs:    r.CertPool(),
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns a client tls config presenting the current certificate,
+// the server certificate is verified for serverName against the CA bundle,
+// or the system roots when no CA file is set
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		},
+	}
+	if r.caFile == "" {
+		return cfg
+	}
+	// RootCAs is fixed once set, verify by hand so a reloaded CA bundle is used
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         r.CertPool(),
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return cfg
+}
+
+// ParseClientAuth converts an app.tls.client-auth value into its tls type
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case ClientAuthNone, "":
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.RequestClientCert, nil
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown tls client-auth: %s", s)
+}
+
+// FromConfig loads the certificates configured by app.tls.* and reloads them
+// until ctx is done. It returns nil when app.ishttps is false.
+func FromConfig(ctx context.Context) (*Reloader, tls.ClientAuthType, error) {
+	app := config.Current().App
+	if !app.IsHttps {
+		return nil, tls.NoClientCert, nil
+	}
+	clientAuth, err := ParseClientAuth(app.TLS.ClientAuth)
+	if err != nil {
+		return nil, clientAuth, err
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && app.TLS.CaFile == "" {
+		return nil, clientAuth, errors.New("tls ca-file is required to verify client certificates")
+	}
+	r, err := NewReloader(app.TLS.CertFile, app.TLS.KeyFile, app.TLS.CaFile)
+	if err != nil {
+		return nil, clientAuth, err
+	}
+	go r.Watch(ctx, app.TLS.ReloadInterval)
+	return r, clientAuth, nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateFile checks a settings file on its own, without flags and environment:
+// yaml syntax, unknown keys, value types and the rules of Settings.Validate.
+// Each error is located by line and column when possible.
+func ValidateFile(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return validateContent(filename, b, true)
+}
+
+// validateContent checks the content of a settings file,
+// the rules of Settings.Validate are skipped unless rules is true
+func validateContent(filename string, b []byte, rules bool) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	positions := make(map[string]string)
+	errs := checkKeys(&root, reflect.TypeOf(Settings{}), "", positions)
+
+	v := viper.New()
+	SetDefaults(v)
+	v.SetConfigType(defaultFileType)
+	if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	s, err := Decode(v)
+	if err != nil {
+		errs = append(errs, err)
+	} else if rules {
+		errs = append(errs, splitErrors(s.Validate())...)
+	}
+
+	for i, err := range errs {
+		var fe *FieldError
+		if errors.As(err, &fe) && fe.Pos == "" {
+			fe.Pos = positions[fe.Key]
+		}
+		if fe != nil && fe.Pos != "" {
+			errs[i] = fmt.Errorf("%s:%w", filename, err)
+		} else {
+			errs[i] = fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkKeys reports keys of node unknown to the settings type t,
+// and records the position of every known key
+func checkKeys(node *yaml.Node, t reflect.Type, prefix string, positions map[string]string) []error {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	var errs []error
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil // a type error, reported when decoding
+		}
+		fields := structFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, val := node.Content[i], node.Content[i+1]
+			key := prefix + strings.ToLower(k.Value)
+			pos := fmt.Sprintf("%d:%d", k.Line, k.Column)
+			ft, ok := fields[strings.ToLower(k.Value)]
+			if !ok {
+				errs = append(errs, &FieldError{Key: key, Msg: "unknown key", Pos: pos})
+				continue
+			}
+			positions[key] = pos
+			errs = append(errs, checkKeys(val, ft, key+".", positions)...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		key := strings.TrimSuffix(prefix, ".")
+		for i, item := range node.Content {
+			errs = append(errs, checkKeys(item, t.Elem(), fmt.Sprintf("%s[%d].", key, i), positions)...)
+		}
+	}
+	return errs
+}
+
+// structFields maps the settings keys of struct t to their types
+func structFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		name, squash := fieldKey(t.Field(i))
+		if squash {
+			for k, ft := range structFields(t.Field(i).Type) {
+				fields[k] = ft
+			}
+			continue
+		}
+		fields[name] = t.Field(i).Type
+	}
+	return fields
+}
+
+// splitErrors flattens joined errors
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, splitErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
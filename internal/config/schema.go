@@ -0,0 +1,614 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Settings is the typed content of the settings file,
+// flags and K8SYNC_* environment variables override file values
+type Settings struct {
+	Daemon  bool            `mapstructure:"daemon"`
+	App     AppSettings     `mapstructure:"app"`
+	Src     SrcSettings     `mapstructure:"src"`
+	Dst     DstSettings     `mapstructure:"dst"`
+	Secret  SecretSettings  `mapstructure:"secret"`
+	Handler HandlerSettings `mapstructure:"handler"`
+	Sync    SyncSettings    `mapstructure:"sync"`
+	Backup  BackupSettings  `mapstructure:"backup"`
+	Policy  PolicySettings  `mapstructure:"policy"`
+	Auth    AuthSettings    `mapstructure:"auth"`
+	Trace   TraceSettings   `mapstructure:"trace"`
+	Log     LogSettings     `mapstructure:"log"`
+}
+
+type AppSettings struct {
+	Host     string      `mapstructure:"host"`
+	HttpPort int         `mapstructure:"http-port"`
+	GrpcPort int         `mapstructure:"grpc-port"`
+	IsHttps  bool        `mapstructure:"ishttps"`
+	TLS      TLSSettings `mapstructure:"tls"`
+	Yaml     bool        `mapstructure:"yaml"` // export source objects as yaml files
+}
+
+type TLSSettings struct {
+	CertFile       string        `mapstructure:"cert-file"`
+	KeyFile        string        `mapstructure:"key-file"`
+	CaFile         string        `mapstructure:"ca-file"`
+	ClientAuth     string        `mapstructure:"client-auth"`
+	ServerName     string        `mapstructure:"server-name"`
+	ReloadInterval time.Duration `mapstructure:"reload-interval"`
+}
+
+type SrcSettings struct {
+	ClusterSettings `mapstructure:",squash"`
+	Namespace       string   `mapstructure:"namespace"`
+	Objects         []string `mapstructure:"objects"`
+	Include         []string `mapstructure:"include"`
+	Exclude         []string `mapstructure:"exclude"`
+	Dependencies    bool     `mapstructure:"dependencies"` // also sync what the synced deployments reference
+}
+
+type DstSettings struct {
+	ClusterSettings `mapstructure:",squash"`
+	Type            string          `mapstructure:"type"`
+	Namespace       string          `mapstructure:"namespace"`
+	Git             GitSettings     `mapstructure:"git"`
+	Service         ServiceSettings `mapstructure:"service"`
+}
+
+// ClusterSettings locate a cluster and its credentials, in order of precedence:
+// server, kube-config-data, kube-config-secret, kube-config, then the default kubeconfig
+// or the in-cluster config
+type ClusterSettings struct {
+	KubeConfig       string            `mapstructure:"kube-config"`        // kubeconfig file
+	Context          string            `mapstructure:"context"`            // kubeconfig context, the current context if empty
+	KubeConfigData   string            `mapstructure:"kube-config-data"`   // inline kubeconfig, plain or base64
+	KubeConfigSecret SecretRefSettings `mapstructure:"kube-config-secret"` // kubeconfig in a Secret of the cluster k8sync runs in
+	Server           string            `mapstructure:"server"`             // api server url, used with ca and token
+	CaFile           string            `mapstructure:"ca-file"`
+	CaData           string            `mapstructure:"ca-data"` // pem, plain or base64
+	Token            string            `mapstructure:"token"`
+	TokenFile        string            `mapstructure:"token-file"`
+	Insecure         bool              `mapstructure:"insecure-skip-tls-verify"`
+	ReloadInterval   time.Duration     `mapstructure:"reload-interval"` // credential reload check interval, 0 to disable
+	QPS              float32           `mapstructure:"qps"`             // requests per second of the client
+	Burst            int               `mapstructure:"burst"`
+	RequestTimeout   time.Duration     `mapstructure:"request-timeout"` // bound of each request except watches, 0 for none
+	UserAgent        string            `mapstructure:"user-agent"`
+	WriteQPS         float32           `mapstructure:"write-qps"` // writes per second, 0 for no limit beyond qps
+	WriteBurst       int               `mapstructure:"write-burst"`
+}
+
+type SecretRefSettings struct {
+	Namespace string `mapstructure:"namespace"` // namespace k8sync runs in if empty
+	Name      string `mapstructure:"name"`
+	Key       string `mapstructure:"key"`
+}
+
+type GitSettings struct {
+	Path        string `mapstructure:"path"`
+	AuthorName  string `mapstructure:"author-name"`
+	AuthorEmail string `mapstructure:"author-email"`
+}
+
+// ServiceSettings adapt synced services to the destination cluster
+type ServiceSettings struct {
+	NodePorts   string           `mapstructure:"node-ports"`    // keep, strip to let the destination allocate, or remap
+	NodePortMap map[string]int32 `mapstructure:"node-port-map"` // source to destination node port when remapped, unmapped ones are stripped
+	// LoadBalancer is keep, or cluster-ip to sync LoadBalancer services as ClusterIP services
+	LoadBalancer            string            `mapstructure:"load-balancer"`
+	LoadBalancerAnnotations map[string]string `mapstructure:"load-balancer-annotations"` // set on LoadBalancer services, e.g. of the destination cloud
+	DropAnnotations         []string          `mapstructure:"drop-annotations"`          // annotation prefixes removed, e.g. of the source cloud
+}
+
+type SecretSettings struct {
+	KeyFile        string `mapstructure:"key-file"`
+	PassphraseFile string `mapstructure:"passphrase-file"`
+}
+
+type HandlerSettings struct {
+	Name string `mapstructure:"name"`
+}
+
+type SyncSettings struct {
+	Timeout  time.Duration `mapstructure:"timeout"`   // deadline of a sync run, 0 for none
+	PageSize int64         `mapstructure:"page-size"` // objects listed per request
+	Workers  int           `mapstructure:"workers"`   // objects written in parallel
+	// OnImmutable is what to do by kind when an update changes an immutable field:
+	// skip (default), recreate, or orphan to recreate a workload and keep its pods
+	OnImmutable       map[string]string `mapstructure:"on-immutable"`
+	IgnoreDifferences []IgnoreSettings  `mapstructure:"ignore-differences"` // destination fields owned by other controllers
+	ThreeWayMerge     bool              `mapstructure:"three-way-merge"`    // keep destination changes which do not conflict with the source
+	Verify            VerifySettings    `mapstructure:"verify"`
+}
+
+// VerifySettings check the rollout of the deployments and services written by a sync
+type VerifySettings struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Timeout  time.Duration `mapstructure:"timeout"`  // bound of the verification of a namespace
+	Rollback bool          `mapstructure:"rollback"` // restore the pre-sync spec of the objects failing verification, delete those the sync created
+}
+
+// IgnoreSettings leave the fields of the objects they match to other controllers
+type IgnoreSettings struct {
+	Kind         string   `mapstructure:"kind"`          // object kind, empty for all kinds
+	Name         string   `mapstructure:"name"`          // object name pattern
+	Selector     string   `mapstructure:"selector"`      // label selector of the source object
+	JSONPointers []string `mapstructure:"json-pointers"` // like /spec/replicas
+	JSONPaths    []string `mapstructure:"json-paths"`    // like {.spec.template.spec.containers[?(@.name=="istio-proxy")]}
+}
+
+type BackupSettings struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Path     string        `mapstructure:"path"`
+	Interval time.Duration `mapstructure:"interval"`
+	Keep     int           `mapstructure:"keep"`
+	MaxAge   time.Duration `mapstructure:"max-age"`
+	Compress bool          `mapstructure:"compress"`
+}
+
+// PolicySettings enables the SyncPolicy controller of the daemon
+type PolicySettings struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Namespace string `mapstructure:"namespace"` // namespace watched for policies, all namespaces if empty
+}
+
+type AuthSettings struct {
+	Enabled     bool                `mapstructure:"enabled"`
+	Tokens      []TokenSettings     `mapstructure:"tokens"`
+	MTLS        MTLSSettings        `mapstructure:"mtls"`
+	TokenReview TokenReviewSettings `mapstructure:"token-review"`
+}
+
+// TokenSettings is a static bearer token, given inline or read from a file
+type TokenSettings struct {
+	Name      string `mapstructure:"name"`
+	Role      string `mapstructure:"role"`
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token-file"`
+}
+
+// SubjectSettings grants a role to a user name or to members of a group
+type SubjectSettings struct {
+	Name  string `mapstructure:"name"`
+	Group string `mapstructure:"group"`
+	Role  string `mapstructure:"role"`
+}
+
+type MTLSSettings struct {
+	Enabled     bool              `mapstructure:"enabled"`
+	Subjects    []SubjectSettings `mapstructure:"subjects"`
+	DefaultRole string            `mapstructure:"default-role"`
+}
+
+type TokenReviewSettings struct {
+	Enabled   bool              `mapstructure:"enabled"`
+	Audiences []string          `mapstructure:"audiences"`
+	Subjects  []SubjectSettings `mapstructure:"subjects"`
+	CacheTTL  time.Duration     `mapstructure:"cache-ttl"`
+}
+
+type TraceSettings struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Exporter    string  `mapstructure:"exporter"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	File        string  `mapstructure:"file"`
+	ServiceName string  `mapstructure:"service-name"`
+	SampleRatio float64 `mapstructure:"sample-ratio"`
+}
+
+type LogSettings struct {
+	Compress      bool   `mapstructure:"compress"`
+	ConsoleStdout bool   `mapstructure:"consolestdout"`
+	FileStdout    bool   `mapstructure:"filestdout"`
+	Level         string `mapstructure:"level"`
+	LocalTime     bool   `mapstructure:"localtime"`
+	MaxAge        int    `mapstructure:"maxage"`
+	MaxBackups    int    `mapstructure:"maxbackups"`
+	MaxSize       int    `mapstructure:"maxsize"`
+	Path          string `mapstructure:"path"`
+	JsonFormat    bool   `mapstructure:"jsonformat"`
+}
+
+var defaultCluster = ClusterSettings{
+	KubeConfigSecret: SecretRefSettings{Key: "kubeconfig"},
+	ReloadInterval:   30 * time.Second,
+	QPS:              50,
+	Burst:            100,
+	RequestTimeout:   30 * time.Second,
+	UserAgent:        "k8sync",
+	WriteQPS:         20,
+	WriteBurst:       40,
+}
+
+// defaults are used for keys missing in the settings file
+var defaults = Settings{
+	App: AppSettings{
+		Host:     "0.0.0.0",
+		HttpPort: 8000,
+		GrpcPort: 8001,
+		TLS: TLSSettings{
+			ClientAuth:     "none",
+			ServerName:     "localhost",
+			ReloadInterval: 30 * time.Second,
+		},
+	},
+	Src: SrcSettings{
+		ClusterSettings: defaultCluster,
+		Objects:         []string{"deployment", "service"},
+	},
+	Dst: DstSettings{
+		ClusterSettings: defaultCluster,
+		Type:            "cluster",
+		Git:             GitSettings{Path: "./manifests", AuthorName: "k8sync", AuthorEmail: "k8sync@localhost"},
+		Service:         ServiceSettings{NodePorts: "keep", LoadBalancer: "keep"},
+	},
+	Handler: HandlerSettings{Name: "default"},
+	Sync: SyncSettings{
+		Timeout:  30 * time.Minute,
+		PageSize: 500,
+		Workers:  4,
+		Verify:   VerifySettings{Timeout: 5 * time.Minute},
+	},
+	Backup: BackupSettings{
+		Path:     "./backups",
+		Interval: time.Hour,
+		Keep:     24,
+		MaxAge:   7 * 24 * time.Hour,
+		Compress: true,
+	},
+	Auth: AuthSettings{TokenReview: TokenReviewSettings{CacheTTL: time.Minute}},
+	Trace: TraceSettings{
+		Exporter:    "otlp",
+		Endpoint:    "localhost:4317",
+		File:        "./logs/trace.json",
+		ServiceName: "k8sync",
+		SampleRatio: 1.0,
+	},
+	Log: LogSettings{
+		Level:      "info",
+		LocalTime:  true,
+		MaxAge:     30,
+		MaxBackups: 300,
+		MaxSize:    10240,
+		Path:       "./logs/k8sync.log",
+	},
+}
+
+var logLevels = []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"}
+
+// SetDefaults registers the default of every settings key in v,
+// so each key can also be set by its environment variable
+func SetDefaults(v *viper.Viper) {
+	for key, val := range flatten(reflect.ValueOf(defaults), "") {
+		v.SetDefault(key, val)
+	}
+}
+
+// flatten maps each leaf key of a settings struct to its value
+func flatten(val reflect.Value, prefix string) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, squash := fieldKey(t.Field(i))
+		key := prefix + name
+		f := val.Field(i)
+		if f.Kind() == reflect.Struct {
+			sub := key + "."
+			if squash {
+				sub = prefix
+			}
+			for k, v := range flatten(f, sub) {
+				out[k] = v
+			}
+			continue
+		}
+		out[key] = f.Interface()
+	}
+	return out
+}
+
+// fieldKey returns the settings key of a struct field,
+// squash reports whether its fields belong to the parent key
+func fieldKey(f reflect.StructField) (name string, squash bool) {
+	tag := f.Tag.Get("mapstructure")
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, opts == "squash"
+}
+
+var current atomic.Pointer[Settings]
+
+// Current returns the settings of the running config
+func Current() *Settings {
+	if s := current.Load(); s != nil {
+		return s
+	}
+	s, err := Decode(viper.GetViper())
+	if err != nil {
+		d := defaults
+		return &d
+	}
+	return s
+}
+
+// Refresh decodes the running config into the settings returned by Current
+func Refresh() error {
+	s, err := Decode(viper.GetViper())
+	if err != nil {
+		return err
+	}
+	current.Store(s)
+	return nil
+}
+
+// Decode converts the content of v into settings
+func Decode(v *viper.Viper) (*Settings, error) {
+	s := &Settings{}
+	if err := v.Unmarshal(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FieldError is an invalid value of a settings key
+type FieldError struct {
+	Key string
+	Msg string
+	Pos string // line:column in the settings file, empty when unknown
+}
+
+func (e *FieldError) Error() string {
+	if e.Pos != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Pos, e.Key, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Msg)
+}
+
+func fieldErrorf(key, format string, args ...interface{}) *FieldError {
+	return &FieldError{Key: key, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks required fields and value ranges,
+// then runs the validators registered with AddValidator
+func (s *Settings) Validate() error {
+	var errs []error
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, fieldErrorf(key, format, args...))
+	}
+
+	if s.App.Host != "" && net.ParseIP(s.App.Host) == nil && strings.ContainsAny(s.App.Host, ":/ ") {
+		add("app.host", "invalid host %q", s.App.Host)
+	}
+	checkPort := func(key string, port int) {
+		if port < 1 || port > 65535 {
+			add(key, "port %d out of range 1-65535", port)
+		}
+	}
+	checkPort("app.http-port", s.App.HttpPort)
+	checkPort("app.grpc-port", s.App.GrpcPort)
+	if s.App.HttpPort == s.App.GrpcPort {
+		add("app.grpc-port", "same as app.http-port %d", s.App.HttpPort)
+	}
+	if s.App.IsHttps {
+		if s.App.TLS.CertFile == "" {
+			add("app.tls.cert-file", "required when app.ishttps is true")
+		}
+		if s.App.TLS.KeyFile == "" {
+			add("app.tls.key-file", "required when app.ishttps is true")
+		}
+		switch s.App.TLS.ClientAuth {
+		case "", "none", "request":
+		case "verify-if-given", "require":
+			if s.App.TLS.CaFile == "" {
+				add("app.tls.ca-file", "required to verify client certificates")
+			}
+		default:
+			add("app.tls.client-auth", "must be one of none, request, verify-if-given, require, got %q", s.App.TLS.ClientAuth)
+		}
+	}
+
+	s.Src.validate("src", add)
+	if s.Dst.Type != "git" {
+		s.Dst.validate("dst", add)
+	}
+	if len(s.Src.Objects) == 0 {
+		add("src.objects", "at least one object kind is required")
+	}
+
+	switch s.Dst.Type {
+	case "cluster":
+	case "git":
+		if s.Dst.Git.Path == "" {
+			add("dst.git.path", "required when dst.type is git")
+		}
+	default:
+		add("dst.type", "must be one of cluster, git, got %q", s.Dst.Type)
+	}
+	switch s.Dst.Service.NodePorts {
+	case "keep", "strip", "remap":
+	default:
+		add("dst.service.node-ports", "must be one of keep, strip, remap, got %q", s.Dst.Service.NodePorts)
+	}
+	for from, to := range s.Dst.Service.NodePortMap {
+		if port, err := strconv.Atoi(from); err != nil || port <= 0 || port > 65535 {
+			add("dst.service.node-port-map", "%q is not a port", from)
+		}
+		if to <= 0 || to > 65535 {
+			add("dst.service.node-port-map."+from, "must be a port, got %d", to)
+		}
+	}
+	switch s.Dst.Service.LoadBalancer {
+	case "keep", "cluster-ip":
+	default:
+		add("dst.service.load-balancer", "must be one of keep, cluster-ip, got %q", s.Dst.Service.LoadBalancer)
+	}
+
+	if s.Sync.Timeout < 0 {
+		add("sync.timeout", "must not be negative, got %s", s.Sync.Timeout)
+	}
+	if s.Sync.PageSize <= 0 {
+		add("sync.page-size", "must be positive, got %d", s.Sync.PageSize)
+	}
+	if s.Sync.Workers <= 0 {
+		add("sync.workers", "must be positive, got %d", s.Sync.Workers)
+	}
+	for kind, policy := range s.Sync.OnImmutable {
+		switch policy {
+		case "skip", "recreate":
+		case "orphan":
+			if kind != "deployment" {
+				add("sync.on-immutable."+kind, "orphan only applies to workloads, got %s", kind)
+			}
+		default:
+			add("sync.on-immutable."+kind, "must be one of skip, recreate, orphan, got %q", policy)
+		}
+	}
+
+	if s.Sync.Verify.Enabled && s.Sync.Verify.Timeout <= 0 {
+		add("sync.verify.timeout", "must be positive, got %s", s.Sync.Verify.Timeout)
+	}
+	for i, r := range s.Sync.IgnoreDifferences {
+		key := fmt.Sprintf("sync.ignore-differences[%d]", i)
+		if len(r.JSONPointers) == 0 && len(r.JSONPaths) == 0 {
+			add(key, "json-pointers or json-paths is required")
+		}
+		if _, err := labels.Parse(r.Selector); err != nil {
+			add(key+".selector", "invalid selector: %s", err)
+		}
+		for _, p := range r.JSONPointers {
+			if !strings.HasPrefix(p, "/") {
+				add(key+".json-pointers", "%q does not start with /", p)
+			}
+		}
+		for _, p := range r.JSONPaths {
+			if !strings.HasPrefix(p, "{") {
+				p = "{" + p + "}"
+			}
+			if _, err := jsonpath.Parse("ignore", p); err != nil {
+				add(key+".json-paths", "invalid json path %q: %s", p, err)
+			}
+		}
+	}
+
+	if s.Backup.Enabled {
+		if s.Backup.Path == "" {
+			add("backup.path", "required when backup is enabled")
+		}
+		if s.Backup.Interval <= 0 {
+			add("backup.interval", "must be positive, got %s", s.Backup.Interval)
+		}
+	}
+	if s.Backup.Keep < 0 {
+		add("backup.keep", "must not be negative, got %d", s.Backup.Keep)
+	}
+
+	if s.Trace.Enabled {
+		switch s.Trace.Exporter {
+		case "otlp", "":
+			if s.Trace.Endpoint == "" {
+				add("trace.endpoint", "required by the otlp exporter")
+			}
+		case "stdout":
+		case "file":
+			if s.Trace.File == "" {
+				add("trace.file", "required by the file exporter")
+			}
+		default:
+			add("trace.exporter", "must be one of otlp, stdout, file, got %q", s.Trace.Exporter)
+		}
+	}
+	if s.Trace.SampleRatio < 0 || s.Trace.SampleRatio > 1 {
+		add("trace.sample-ratio", "must be between 0 and 1, got %s", strconv.FormatFloat(s.Trace.SampleRatio, 'g', -1, 64))
+	}
+
+	if s.Log.Level != "" && !contains(logLevels, s.Log.Level) {
+		add("log.level", "must be one of %s, got %q", strings.Join(logLevels, ", "), s.Log.Level)
+	}
+
+	if err := validate(s); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the cluster settings under key prefix
+func (c *ClusterSettings) validate(prefix string, add func(key, format string, args ...interface{})) {
+	var sources []string
+	if c.KubeConfig != "" {
+		sources = append(sources, "kube-config")
+	}
+	if c.KubeConfigData != "" {
+		sources = append(sources, "kube-config-data")
+	}
+	if c.KubeConfigSecret.Name != "" {
+		sources = append(sources, "kube-config-secret")
+	}
+	if c.Server != "" {
+		sources = append(sources, "server")
+		if u, err := url.Parse(c.Server); err != nil || u.Host == "" {
+			add(prefix+".server", "invalid url %q", c.Server)
+		}
+		if c.Context != "" {
+			add(prefix+".context", "can not be used with server")
+		}
+	} else {
+		keys := []string{"ca-file", "ca-data", "token", "token-file"}
+		for i, val := range []string{c.CaFile, c.CaData, c.Token, c.TokenFile} {
+			if val != "" {
+				add(prefix+"."+keys[i], "requires %s.server", prefix)
+			}
+		}
+	}
+	if len(sources) > 1 {
+		add(prefix+"."+sources[1], "can not be used with %s.%s", prefix, sources[0])
+	}
+	if c.CaFile != "" && c.CaData != "" {
+		add(prefix+".ca-data", "can not be used with %s.ca-file", prefix)
+	}
+	if c.Token != "" && c.TokenFile != "" {
+		add(prefix+".token-file", "can not be used with %s.token", prefix)
+	}
+	if c.KubeConfigSecret.Name != "" && c.KubeConfigSecret.Key == "" {
+		add(prefix+".kube-config-secret.key", "required")
+	}
+	if c.ReloadInterval < 0 {
+		add(prefix+".reload-interval", "must not be negative, got %s", c.ReloadInterval)
+	}
+	if c.QPS < 0 {
+		add(prefix+".qps", "must not be negative, got %v", c.QPS)
+	}
+	if c.Burst < 0 {
+		add(prefix+".burst", "must not be negative, got %d", c.Burst)
+	}
+	if c.RequestTimeout < 0 {
+		add(prefix+".request-timeout", "must not be negative, got %s", c.RequestTimeout)
+	}
+	if c.WriteQPS < 0 {
+		add(prefix+".write-qps", "must not be negative, got %v", c.WriteQPS)
+	}
+	if c.WriteQPS > 0 && c.WriteBurst < 1 {
+		add(prefix+".write-burst", "must be at least 1 when write-qps is set, got %d", c.WriteBurst)
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Validator checks settings beyond the rules of Settings.Validate,
+// it may return FieldErrors to name the invalid keys
+type Validator func(s *Settings) error
+
+// settleDelay is the time without writes after which a changed config file is read
+const settleDelay = 100 * time.Millisecond
+
+type namedValidator struct {
+	name string
+	fn   Validator
+}
+
+var (
+	validatorsMu sync.RWMutex
+	validators   []namedValidator
+
+	watchMu   sync.Mutex
+	reloaders []func()
+	lastGood  []byte // content of the running config file
+	lastBad   []byte // content of the last rejected config file
+	overlay   func(v *viper.Viper) error
+)
+
+// AddValidator registers a check run by Settings.Validate
+func AddValidator(name string, fn Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = append(validators, namedValidator{name: name, fn: fn})
+}
+
+// OnReload registers fn to apply a reloaded config, it runs after validation passed
+func OnReload(fn func()) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	reloaders = append(reloaders, fn)
+}
+
+func validate(s *Settings) error {
+	validatorsMu.RLock()
+	defer validatorsMu.RUnlock()
+	var errs []error
+	for _, nv := range validators {
+		for _, err := range splitErrors(nv.fn(s)) {
+			var fe *FieldError
+			if !errors.As(err, &fe) {
+				err = &FieldError{Key: nv.name, Msg: err.Error()}
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetOverlay registers fn applying the flags and environment of the process to a viper and applies it
+// to the global one. a changed config file is validated on a private viper with them
+func SetOverlay(fn func(v *viper.Viper) error) error {
+	watchMu.Lock()
+	overlay = fn
+	watchMu.Unlock()
+	return fn(viper.GetViper())
+}
+
+// Watch reloads the config file when it changes, a mounted ConfigMap included.
+// A config which fails to parse or validate is not applied, the running one is kept,
+// and reported to onError.
+func Watch(onError func(err error)) error {
+	filename := viper.ConfigFileUsed()
+	if filename == "" {
+		return errors.New("no config file to watch")
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	watchMu.Lock()
+	lastGood = b
+	watchMu.Unlock()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// the directory is watched, a ConfigMap volume swaps the file by a symlink of the directory
+	if err = watcher.Add(filepath.Dir(filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+	realPath, _ := filepath.EvalSymlinks(filename)
+	go func() {
+		defer watcher.Close()
+		// a file is read once its writes settled, a truncated file is not reloaded
+		settle := time.NewTimer(0)
+		<-settle.C
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				linked, _ := filepath.EvalSymlinks(filename)
+				written := filepath.Clean(e.Name) == filepath.Clean(filename) && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if !written && (linked == "" || linked == realPath) {
+					continue
+				}
+				realPath = linked
+				settle.Reset(settleDelay)
+			case <-settle.C:
+				if err := reload(filename); err != nil {
+					onError(err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(fmt.Errorf("watch config %s failed: %w", filename, err))
+			}
+		}
+	}()
+	return nil
+}
+
+// reload reads the changed file into a private viper with the flags and environment applied
+// and validates it, only a valid config is read into the global viper and becomes current
+func reload(filename string) error {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("read config %s failed: %w", filename, err)
+	}
+	if bytes.Equal(b, lastGood) || bytes.Equal(b, lastBad) {
+		// unchanged or already reported
+		return nil
+	}
+
+	// the file is checked on its own, then with flags and environment applied
+	err = validateContent(filename, b, false)
+	var s *Settings
+	if err == nil {
+		v := viper.New()
+		SetDefaults(v)
+		v.SetConfigType(defaultFileType)
+		if overlay != nil {
+			err = overlay(v)
+		}
+		if err == nil {
+			err = v.ReadConfig(bytes.NewReader(b))
+		}
+		if err == nil {
+			s, err = Decode(v)
+		}
+		if err == nil {
+			err = s.Validate()
+		}
+	}
+	if err != nil {
+		lastBad = b
+		return fmt.Errorf("invalid config %s not applied: %w", filename, err)
+	}
+
+	if err = viper.ReadConfig(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("read config %s failed: %w", filename, err)
+	}
+	current.Store(s)
+	lastGood, lastBad = b, nil
+	for _, fn := range reloaders {
+		fn()
+	}
+	return nil
+}
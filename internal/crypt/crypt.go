@@ -0,0 +1,232 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"k8sync/internal/config"
+)
+
+const (
+	valuePrefix = "ENC[age,"
+	valueSuffix = "]"
+	// scrypt work factor for passphrase, each secret value is encrypted on its own
+	scryptWorkFactor = 15
+)
+
+// Cipher encrypts secret values with age, either to the X25519 recipients
+// of a key file or to a passphrase
+type Cipher struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+var (
+	curr     *Cipher
+	currErr  error
+	currOnce sync.Once
+)
+
+// Curr returns the cipher configured by secret.key-file or secret.passphrase-file
+func Curr() (*Cipher, error) {
+	currOnce.Do(func() {
+		settings := config.Current().Secret
+		curr, currErr = New(settings.KeyFile, settings.PassphraseFile)
+	})
+	return curr, currErr
+}
+
+// New creates a cipher from an age identity file or a passphrase file,
+// the key file takes precedence when both are given
+func New(keyFile, passphraseFile string) (*Cipher, error) {
+	switch {
+	case keyFile != "":
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		ids, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("parse age key file %s failed: %w", keyFile, err)
+		}
+		c := &Cipher{identities: ids}
+		for _, id := range ids {
+			if x, ok := id.(*age.X25519Identity); ok {
+				c.recipients = append(c.recipients, x.Recipient())
+			}
+		}
+		if len(c.recipients) == 0 {
+			return nil, fmt.Errorf("no X25519 key in %s", keyFile)
+		}
+		return c, nil
+	case passphraseFile != "":
+		b, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase := strings.TrimSpace(string(b))
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		r.SetWorkFactor(scryptWorkFactor)
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &Cipher{recipients: []age.Recipient{r}, identities: []age.Identity{id}}, nil
+	}
+	return nil, errors.New("secret encryption key is not configured, set secret.key-file or secret.passphrase-file")
+}
+
+// IsEncrypted reports whether value is produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, valuePrefix) && strings.HasSuffix(value, valueSuffix)
+}
+
+// Encrypt encrypts plain into a printable value
+func (c *Cipher) Encrypt(plain []byte) (string, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, c.recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(plain); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return valuePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()) + valueSuffix, nil
+}
+
+// Decrypt decrypts a value produced by Encrypt
+func (c *Cipher) Decrypt(value string) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return nil, errors.New("value is not encrypted")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, valuePrefix), valueSuffix))
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(b), c.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// EncryptSecret encrypts the data and stringData values of a Secret in place,
+// keys and metadata stay readable
+func (c *Cipher) EncryptSecret(u *unstructured.Unstructured) error {
+	return c.walkSecret(u, func(field, value string) (string, error) {
+		if IsEncrypted(value) {
+			return value, nil
+		}
+		plain := []byte(value)
+		if field == "data" {
+			var err error
+			if plain, err = base64.StdEncoding.DecodeString(value); err != nil {
+				return "", err
+			}
+		}
+		return c.Encrypt(plain)
+	})
+}
+
+// DecryptSecret reverts EncryptSecret
+func (c *Cipher) DecryptSecret(u *unstructured.Unstructured) error {
+	return c.walkSecret(u, func(field, value string) (string, error) {
+		if !IsEncrypted(value) {
+			return value, nil
+		}
+		plain, err := c.Decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		if field == "data" {
+			return base64.StdEncoding.EncodeToString(plain), nil
+		}
+		return string(plain), nil
+	})
+}
+
+func (c *Cipher) walkSecret(u *unstructured.Unstructured, fn func(field, value string) (string, error)) error {
+	if u.GetKind() != "Secret" {
+		return nil
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, found, err := unstructured.NestedStringMap(u.Object, field)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		for k, v := range values {
+			if values[k], err = fn(field, v); err != nil {
+				return fmt.Errorf("secret %s %s.%s: %w", u.GetName(), field, k, err)
+			}
+		}
+		if err = unstructured.SetNestedStringMap(u.Object, values, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasEncrypted reports whether the yaml manifest is a Secret holding encrypted values
+func HasEncrypted(data []byte) bool {
+	return bytes.Contains(data, []byte(valuePrefix))
+}
+
+// DecryptManifest decrypts the yaml manifest of a Secret,
+// other manifests are returned unchanged
+func DecryptManifest(data []byte) ([]byte, error) {
+	if !HasEncrypted(data) {
+		return data, nil
+	}
+	u := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u.Object); err != nil {
+		return nil, err
+	}
+	c, err := Curr()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.DecryptSecret(u); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(u.Object)
+}
+
+// SameManifest reports whether two yaml manifests are equal after decryption,
+// age output is randomized so the encrypted text changes on every export
+func SameManifest(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if !HasEncrypted(a) || !HasEncrypted(b) {
+		return false
+	}
+	da, err := DecryptManifest(a)
+	if err != nil {
+		return false
+	}
+	db, err := DecryptManifest(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(da, db)
+}
@@ -2,25 +2,42 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"google.golang.org/grpc/credentials/insecure"
 	"io/fs"
 	"mime"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/grpclog"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protojson"
 
 	"k8sync/gen/proto/k8sync/v1"
+	"k8sync/internal/auth"
+	"k8sync/internal/certs"
 	"k8sync/internal/config"
+	"k8sync/internal/metrics"
 	"k8sync/internal/process"
 	log "k8sync/pkg/logger"
 	"k8sync/third_party"
 )
 
+// healthCheckInterval is how often the grpc health service status is refreshed
+const healthCheckInterval = 10 * time.Second
+
+// defaultServerName is the name verified in the grpc server certificate by the gateway
+const defaultServerName = "localhost"
+
 // getOpenAPIHandler serves an OpenAPI UI.
 func getOpenAPIHandler() http.Handler {
 	err := mime.AddExtensionType(".svg", "image/svg+xml")
@@ -35,25 +52,90 @@ func getOpenAPIHandler() http.Handler {
 	return http.StripPrefix("/swagger", http.FileServer(http.FS(subFS)))
 }
 
+// serveHealthz is the liveness probe, the daemon is alive as long as it answers.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// serveReadyz is the readiness probe, it fails until the clusters are
+// reachable and the informer cache is synced.
+func serveReadyz(w http.ResponseWriter, r *http.Request, health *process.Health) {
+	resp, ready := health.Ready(r.Context())
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(body)
+}
+
+// Services are the grpc services served by the gateway,
+// a nil service is not registered
+type Services struct {
+	Health *process.Health
+	Backup *process.Backup
+	Sync   *process.Sync
+	Events *process.Events
+	Auth   *auth.Authorizer // nil serves every rpc without authentication
+}
+
 // Start runs the gRPC-Gateway, dialling the provided address.
-func Start(ctx context.Context) error {
+// Both servers use TLS when app.ishttps is set.
+func Start(ctx context.Context, svc *Services) error {
 	grpclog.SetLoggerV2(log.GetGrpcLogger())
 
+	tlsCerts, clientAuth, err := certs.FromConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("load tls certificates failed: %w", err)
+	}
 	grpcAddr := config.GetAppGrpcDomain()
-	startGrpcServer(ctx, grpcAddr)
-	return startHttpServer(ctx, grpcAddr)
+	startGrpcServer(ctx, grpcAddr, svc, tlsCerts, clientAuth)
+	return startHttpServer(ctx, grpcAddr, svc, tlsCerts, clientAuth)
+}
+
+func scheme(tlsCerts *certs.Reloader) string {
+	if tlsCerts != nil {
+		return "https://"
+	}
+	return "http://"
 }
 
-func startGrpcServer(ctx context.Context, grpcAddr string) {
+func startGrpcServer(ctx context.Context, grpcAddr string, svc *Services, tlsCerts *certs.Reloader, clientAuth tls.ClientAuthType) {
 	lis, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
 		log.Fatal("Failed to listen:", err)
 	}
-	gsv := grpc.NewServer()
-	pb.RegisterHealthServiceServer(gsv, process.NewHealth())
+	opts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+	if svc.Auth != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(svc.Auth.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(svc.Auth.StreamServerInterceptor()))
+	}
+	if tlsCerts != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCerts.ServerConfig(clientAuth))))
+	}
+	gsv := grpc.NewServer(opts...)
+	pb.RegisterHealthServiceServer(gsv, svc.Health)
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(gsv, hs)
+	go svc.Health.Watch(ctx, hs, healthCheckInterval)
+	if svc.Backup != nil {
+		pb.RegisterBackupServiceServer(gsv, svc.Backup)
+	}
+	if svc.Sync != nil {
+		pb.RegisterSyncServiceServer(gsv, svc.Sync)
+	}
+	if svc.Events != nil {
+		pb.RegisterEventServiceServer(gsv, svc.Events)
+	}
 
 	// Serve gRPC Server
-	log.Info("Serving gRPC on http://", grpcAddr)
+	log.Info("Serving gRPC on ", scheme(tlsCerts), grpcAddr)
 	go func() {
 		defer gsv.GracefulStop()
 		<-ctx.Done()
@@ -65,38 +147,94 @@ func startGrpcServer(ctx context.Context, grpcAddr string) {
 	}()
 }
 
-func startHttpServer(ctx context.Context, grpcAddr string) error {
+func startHttpServer(ctx context.Context, grpcAddr string, svc *Services, tlsCerts *certs.Reloader, clientAuth tls.ClientAuthType) error {
 	// Create a client connection to the gRPC Server we just started.
 	// This is where the gRPC-Gateway proxies the requests.
+	creds := insecure.NewCredentials()
+	if tlsCerts != nil {
+		serverName := config.Current().App.TLS.ServerName
+		if serverName == "" {
+			serverName = defaultServerName
+		}
+		// the gateway presents the server certificate as its client certificate
+		creds = credentials.NewTLS(tlsCerts.ClientConfig(serverName))
+	}
 	conn, err := grpc.NewClient(
 		"dns:///"+grpcAddr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		//grpc.WithBlock(),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to dial server: %w", err)
 	}
 
-	gwmux := runtime.NewServeMux()
+	var muxOpts []runtime.ServeMuxOption
+	if svc.Auth != nil {
+		muxOpts = append(muxOpts, runtime.WithMetadata(svc.Auth.GatewayMetadata))
+	}
+	gwmux := runtime.NewServeMux(muxOpts...)
 	err = pb.RegisterHealthServiceHandler(ctx, gwmux, conn)
 	if err != nil {
 		return fmt.Errorf("register user service handler failed: %w", err)
 	}
+	if svc.Backup != nil {
+		err = pb.RegisterBackupServiceHandler(ctx, gwmux, conn)
+		if err != nil {
+			return fmt.Errorf("register backup service handler failed: %w", err)
+		}
+	}
+	if svc.Sync != nil {
+		err = pb.RegisterSyncServiceHandler(ctx, gwmux, conn)
+		if err != nil {
+			return fmt.Errorf("register sync service handler failed: %w", err)
+		}
+	}
+	if svc.Events != nil {
+		err = pb.RegisterEventServiceHandler(ctx, gwmux, conn)
+		if err != nil {
+			return fmt.Errorf("register event service handler failed: %w", err)
+		}
+	}
 
 	swagger := getOpenAPIHandler()
+	metricsHandler := metrics.Handler()
 	gatewayAddr := config.GetAppHttpDomain()
 	gwServer := &http.Server{
 		Addr: gatewayAddr,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handler: otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if strings.HasPrefix(r.URL.Path, "/swagger/") {
 				swagger.ServeHTTP(w, r)
 				return
 			}
+			switch r.URL.Path {
+			case "/healthz":
+				serveHealthz(w, r)
+				return
+			case "/readyz":
+				serveReadyz(w, r, svc.Health)
+				return
+			case "/metrics":
+				metricsHandler.ServeHTTP(w, r)
+				return
+			}
+			if svc.Auth != nil {
+				r = svc.Auth.AuthenticateHTTP(r)
+			}
+			if r.URL.Path == "/events" && svc.Events != nil {
+				if svc.Auth == nil || svc.Auth.AuthorizeHTTP(w, r, auth.RequiredRole(pb.EventService_WatchEvents_FullMethodName)) {
+					serveEvents(w, r, svc.Events)
+				}
+				return
+			}
 			gwmux.ServeHTTP(w, r)
-		}),
+		}), "gateway"),
+	}
+	if tlsCerts != nil {
+		gwServer.TLSConfig = tlsCerts.ServerConfig(clientAuth)
 	}
 
-	log.Info("serving gRPC-Gateway and OpenAPI Documentation on http://", gatewayAddr)
+	log.Info("serving gRPC-Gateway and OpenAPI Documentation on ", scheme(tlsCerts), gatewayAddr)
 	go func() {
 		<-ctx.Done()
 		log.Infof("shutting down http gateway server")
@@ -105,6 +243,10 @@ func startHttpServer(ctx context.Context, grpcAddr string) error {
 		}
 	}()
 	go func() {
+		if tlsCerts != nil {
+			log.Warnf("serving grpc-gateway server %s", gwServer.ListenAndServeTLS("", ""))
+			return
+		}
 		log.Warnf("serving grpc-gateway server %s", gwServer.ListenAndServe())
 	}()
 
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"k8sync/gen/proto/k8sync/v1"
+	"k8sync/internal/process"
+	log "k8sync/pkg/logger"
+)
+
+// serveEvents streams events as Server-Sent Events,
+// the query parameters namespace, kind and status filter events and can be repeated.
+func serveEvents(w http.ResponseWriter, r *http.Request, events *process.Events) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	sub := events.Subscribe(&pb.WatchEventsRequest{
+		Namespaces: query["namespace"],
+		Kinds:      query["kind"],
+		Statuses:   query["status"],
+	})
+	defer events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, err := protojson.Marshal(process.EventToPB(e))
+			if err != nil {
+				log.Errorf("marshal event failed: %s", err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Reason, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8sync/internal/crypt"
+)
+
+// annotations which are maintained by the cluster and must not land in git
+var volatileAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+}
+
+// Manifest converts a typed k8s object into a sanitized yaml manifest.
+// Cluster owned fields (status, uid, resourceVersion, managedFields ...)
+// are dropped, so the output only changes when the object spec changes.
+// Secret values are encrypted by the configured cipher.
+func Manifest(obj runtime.Object) ([]byte, error) {
+	obj = obj.DeepCopyObject()
+	if obj.GetObjectKind().GroupVersionKind().Empty() {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			return nil, fmt.Errorf("get object kind failed: %w", err)
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("convert object failed: %w", err)
+	}
+	u := &unstructured.Unstructured{Object: content}
+	sanitize(u)
+	if u.GetKind() == "Secret" {
+		c, err := crypt.Curr()
+		if err != nil {
+			return nil, err
+		}
+		if err = c.EncryptSecret(u); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	y := printers.YAMLPrinter{}
+	if err := y.PrintObj(u, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sanitize(u *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(u.Object, "status")
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "metadata", "generation")
+	unstructured.RemoveNestedField(u.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(u.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(u.Object, "metadata", "selfLink")
+	unstructured.RemoveNestedField(u.Object, "metadata", "uid")
+	if annotations := u.GetAnnotations(); annotations != nil {
+		for _, a := range volatileAnnotations {
+			delete(annotations, a)
+		}
+		u.SetAnnotations(annotations)
+	}
+	if u.GetKind() == "Service" {
+		unstructured.RemoveNestedField(u.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(u.Object, "spec", "clusterIPs")
+	}
+}
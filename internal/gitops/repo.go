@@ -0,0 +1,208 @@
+package gitops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8sync/internal/crypt"
+	"k8sync/pkg/logger"
+)
+
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+)
+
+// Repo writes sanitized manifests into a local git working tree with
+// the layout <namespace>/<kind>/<name>.yaml
+type Repo struct {
+	path        string
+	authorName  string
+	authorEmail string
+	mu          sync.Mutex
+	changes     map[string]string // changed file -> action
+}
+
+// Open opens the git working tree at path, it will be initialized
+// when path is not a git repository yet
+func Open(path, authorName, authorEmail string) (*Repo, error) {
+	if path == "" {
+		return nil, errors.New("git repository path is empty")
+	}
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return nil, err
+	}
+	r := &Repo{
+		path:        path,
+		authorName:  authorName,
+		authorEmail: authorEmail,
+		changes:     make(map[string]string),
+	}
+	if _, err := os.Stat(filepath.Join(path, ".git")); os.IsNotExist(err) {
+		logger.Infof("init git repository: %s", path)
+		if _, err = r.git("init"); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Path returns the root of the working tree
+func (r *Repo) Path() string {
+	return r.path
+}
+
+// Write saves the manifest of obj, the file is only touched when its content changes
+func (r *Repo) Write(ns, kind string, obj runtime.Object) error {
+	data, err := Manifest(obj)
+	if err != nil {
+		return err
+	}
+	name, err := objectName(obj)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file := r.file(ns, kind, name)
+	action := actionCreate
+	if old, err := os.ReadFile(filepath.Join(r.path, file)); err == nil {
+		if crypt.SameManifest(old, data) {
+			return nil
+		}
+		action = actionUpdate
+	}
+	if err = os.MkdirAll(filepath.Join(r.path, filepath.Dir(file)), 0750); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(r.path, file), data, 0640); err != nil {
+		return err
+	}
+	r.record(file, action)
+	return nil
+}
+
+// Remove deletes the manifest of object kind/name in namespace ns
+func (r *Repo) Remove(ns, kind, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file := r.file(ns, kind, name)
+	err := os.Remove(filepath.Join(r.path, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.record(file, actionDelete)
+	return nil
+}
+
+// Names lists the names of all saved objects of kind in namespace ns
+func (r *Repo) Names(ns, kind string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.path, ns, kind))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".yaml" {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	return names, nil
+}
+
+// Commit commits all pending changes, nothing happens when there is no change
+func (r *Repo) Commit() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.changes) == 0 {
+		return nil
+	}
+	if _, err := r.git("add", "-A", "--", "."); err != nil {
+		return err
+	}
+	status, err := r.git("status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status) == "" {
+		r.changes = make(map[string]string)
+		return nil
+	}
+	msg := r.message()
+	if _, err = r.git("commit", "-q", "-m", msg); err != nil {
+		return err
+	}
+	logger.Infof("git commit %d changed objects in %s", len(r.changes), r.path)
+	r.changes = make(map[string]string)
+	return nil
+}
+
+func (r *Repo) record(file, action string) {
+	// an object created and updated before commit is still a new one
+	if prev, ok := r.changes[file]; ok && prev == actionCreate && action == actionUpdate {
+		return
+	}
+	r.changes[file] = action
+}
+
+func (r *Repo) message() string {
+	files := make([]string, 0, len(r.changes))
+	for f := range r.changes {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "k8sync: sync %d objects\n\n", len(files))
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s %s\n", r.changes[f], strings.TrimSuffix(filepath.ToSlash(f), ".yaml"))
+	}
+	return b.String()
+}
+
+func (r *Repo) file(ns, kind, name string) string {
+	return filepath.Join(ns, kind, name+".yaml")
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	if r.authorName != "" {
+		args = append([]string{"-c", "user.name=" + r.authorName}, args...)
+	}
+	if r.authorEmail != "" {
+		args = append([]string{"-c", "user.email=" + r.authorEmail}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.path
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func objectName(obj runtime.Object) (string, error) {
+	accessor, ok := obj.(interface{ GetName() string })
+	if !ok {
+		return "", fmt.Errorf("object %T has no name", obj)
+	}
+	if accessor.GetName() == "" {
+		return "", fmt.Errorf("object %T has empty name", obj)
+	}
+	return accessor.GetName(), nil
+}
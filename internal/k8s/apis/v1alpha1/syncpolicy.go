@@ -0,0 +1,130 @@
+// Package v1alpha1 holds the k8sync.io/v1alpha1 custom resources,
+// they are read and written as unstructured objects by the dynamic client
+package v1alpha1
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "k8sync.io"
+	Version = "v1alpha1"
+
+	// DefaultKubeconfigKey is the key of the kubeconfig in a cluster Secret
+	DefaultKubeconfigKey = "kubeconfig"
+
+	// DefaultVerifyTimeout bounds the verification of a sync when VerifySpec has no timeout
+	DefaultVerifyTimeout = 5 * time.Minute
+
+	// condition types of a SyncPolicy
+	ConditionReady  = "Ready"  // the policy is valid and both clusters are reachable
+	ConditionSynced = "Synced" // the last sync succeeded
+)
+
+// SyncPolicyResource is the resource of SyncPolicy objects
+var SyncPolicyResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "syncpolicies"}
+
+// SyncPolicy declares a sync job from a source to a destination namespace
+type SyncPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SyncPolicySpec   `json:"spec"`
+	Status SyncPolicyStatus `json:"status,omitempty"`
+}
+
+type SyncPolicySpec struct {
+	Source       ClusterRef  `json:"source"`
+	Destination  ClusterRef  `json:"destination"`
+	Kinds        []string    `json:"kinds,omitempty"`   // object kinds, deployment and service by default
+	Include      []string    `json:"include,omitempty"` // object name patterns, empty to include all
+	Exclude      []string    `json:"exclude,omitempty"` // object name patterns
+	Transforms   []Transform `json:"transforms,omitempty"`
+	Dependencies bool        `json:"dependencies,omitempty"` // also sync the objects the synced deployments reference
+	Schedule     string      `json:"schedule,omitempty"`     // sync interval like 10m, empty to sync on changes of the policy only
+	Suspend      bool        `json:"suspend,omitempty"`
+	// IgnoreDifferences are destination fields owned by other controllers, neither compared nor overwritten
+	IgnoreDifferences []IgnoreRule `json:"ignoreDifferences,omitempty"`
+	ThreeWayMerge     bool         `json:"threeWayMerge,omitempty"` // keep destination changes which do not conflict with the source
+	Verify            *VerifySpec  `json:"verify,omitempty"`        // verify the rollout after each sync
+}
+
+// ClusterRef locates a namespace, in the cluster k8sync runs in
+// unless a Secret with a kubeconfig is given
+type ClusterRef struct {
+	KubeconfigSecretRef *SecretKeyRef `json:"kubeconfigSecretRef,omitempty"`
+	Namespace           string        `json:"namespace,omitempty"` // destination defaults to the source namespace
+}
+
+// SecretKeyRef is a key of a Secret in the namespace of the policy
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Transform changes source objects before they are applied to the destination
+type Transform struct {
+	Kinds       []string          `json:"kinds,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Images      []ImageRewrite    `json:"images,omitempty"`
+	Replicas    *int32            `json:"replicas,omitempty"`
+}
+
+// IgnoreRule selects fields of the objects of a kind by JSON pointer or JSONPath
+type IgnoreRule struct {
+	Kind         string   `json:"kind,omitempty"`
+	Name         string   `json:"name,omitempty"`     // object name pattern
+	Selector     string   `json:"selector,omitempty"` // label selector of the source object
+	JSONPointers []string `json:"jsonPointers,omitempty"`
+	JSONPaths    []string `json:"jsonPaths,omitempty"`
+}
+
+// VerifySpec checks that the deployments written came up and the services written have ready endpoints
+type VerifySpec struct {
+	Timeout  string `json:"timeout,omitempty"`  // like 5m, 5m by default
+	Rollback bool   `json:"rollback,omitempty"` // restore the pre-sync spec of the objects failing verification, delete those the sync created
+}
+
+// ImageRewrite replaces the image prefix From with To
+type ImageRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type SyncPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	LastSyncTime       *metav1.Time       `json:"lastSyncTime,omitempty"`
+	NextSyncTime       *metav1.Time       `json:"nextSyncTime,omitempty"`
+	LastSyncResult     *SyncResult        `json:"lastSyncResult,omitempty"`
+}
+
+// SyncResult counts the objects of a sync
+type SyncResult struct {
+	Succeeded int32  `json:"succeeded"`
+	Failed    int32  `json:"failed"`
+	Conflicts int32  `json:"conflicts,omitempty"` // objects merged with fields changed on both sides
+	Duration  string `json:"duration,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FromUnstructured converts an object read by the dynamic client
+func FromUnstructured(u *unstructured.Unstructured) (*SyncPolicy, error) {
+	p := &SyncPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, p); err != nil {
+		return nil, fmt.Errorf("decode syncpolicy %s/%s failed: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return p, nil
+}
+
+// StatusToUnstructured converts the status of p to be written by the dynamic client
+func (p *SyncPolicy) StatusToUnstructured() (map[string]interface{}, error) {
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(&p.Status)
+}
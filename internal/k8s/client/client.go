@@ -1,48 +1,148 @@
 package client
 
 import (
-	"github.com/spf13/viper"
+	"context"
+	"fmt"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8sync/internal/config"
+	"k8sync/internal/metrics"
+	"k8sync/internal/tracing"
 	"k8sync/pkg/logger"
+	"net/http"
 	"os"
 	"strings"
 
+	"k8s.io/client-go/dynamic"
 	clientcore "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	clientreset "k8s.io/client-go/rest"
 	clientmetrics "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
 type K8s struct {
 	Clientset        clientcore.Interface
-	MetricsClientSet *clientmetrics.Clientset
-	RestConfig       *clientreset.Config
-	namesapce        string // current namespace
-	outOfCluster     bool   // out of cluster config
+	Dynamic          dynamic.Interface
+	Metadata         metadata.Interface // lists object metadata only
+	MetricsClientSet clientmetrics.Interface
+	RestConfig       *clientreset.Config // nil when the clients are injected
+	namesapce        string              // current namespace
+	outOfCluster     bool                // out of cluster config
+	creds            *credentials
 }
 
 // New creates a new k8s client
-// cluster - used for get kubeconfig. refer getRestConfig
-func New(cluster string) *K8s {
+// cluster - src or dst, used for get the cluster settings. refer config.ClusterSettings
+func New(cluster string) (*K8s, error) {
 	var err error
+	var inCluster bool
 	k := K8s{}
 
-	k.RestConfig, err = k.getRestConfig(cluster)
+	settings := clusterSettings(cluster)
+	k.creds, k.RestConfig, inCluster, err = newCredentials(cluster, settings)
 	if err != nil {
-		logger.Fatalf("get %s cluster config failed: %v", cluster, err)
-		return nil
+		return nil, fmt.Errorf("get %s cluster config failed: %w", cluster, err)
+	}
+	k.outOfCluster = !inCluster
+	if inCluster {
+		logger.Infof("use %s cluster internal config", cluster)
+	} else {
+		logger.Infof("use %s cluster %s", cluster, describeSource(settings))
+	}
+	if err = k.init(cluster, settings); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// clusterSettings returns the settings of cluster src or dst
+func clusterSettings(cluster string) config.ClusterSettings {
+	if cluster == "dst" {
+		return config.Current().Dst.ClusterSettings
+	}
+	return config.Current().Src.ClusterSettings
+}
+
+// NewForClients creates a k8s client of existing clients in namespace,
+// e.g. fake clientsets, the dynamic and metrics clients may be nil
+func NewForClients(clientset clientcore.Interface, dyn dynamic.Interface, meta metadata.Interface,
+	metrics clientmetrics.Interface, namespace string) *K8s {
+	return &K8s{
+		Clientset:        clientset,
+		Dynamic:          dyn,
+		Metadata:         meta,
+		MetricsClientSet: metrics,
+		namesapce:        namespace,
+		outOfCluster:     true,
+	}
+}
+
+// NewFromKubeconfig creates a k8s client from the content of a kubeconfig file,
+// the namespace of its current context is used when set. the QPS, burst, request timeout,
+// user agent and write limit are those of the src or dst cluster settings
+func NewFromKubeconfig(cluster string, kubeconfig []byte) (*K8s, error) {
+	k := K8s{outOfCluster: true}
+
+	cc, err := clientcmd.NewClientConfigFromBytes(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("load %s cluster kubeconfig failed: %w", cluster, err)
+	}
+	if k.RestConfig, err = cc.ClientConfig(); err != nil {
+		return nil, fmt.Errorf("get %s cluster config failed: %w", cluster, err)
+	}
+	if ns, _, err := cc.Namespace(); err == nil {
+		k.namesapce = ns
+	}
+	if err = k.init(cluster, clusterSettings(cluster)); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// init creates the clientsets of k.RestConfig with the client limits of settings, the request timeout
+// and write limit wrap the instrumented transport so the time waiting for a limit is not measured as request latency
+func (k *K8s) init(cluster string, settings config.ClusterSettings) error {
+	var err error
+	k.RestConfig.QPS, k.RestConfig.Burst, k.RestConfig.UserAgent = settings.QPS, settings.Burst, settings.UserAgent
+	var limits []func(http.RoundTripper) http.RoundTripper
+	if settings.RequestTimeout > 0 {
+		limits = append(limits, requestTimeout(settings.RequestTimeout))
+	}
+	if settings.WriteQPS > 0 {
+		limits = append(limits, limitWrites(settings.WriteQPS, settings.WriteBurst))
+	}
+	k.RestConfig.Wrap(metrics.InstrumentTransport(cluster))
+	k.RestConfig.Wrap(tracing.InstrumentTransport(cluster))
+	for _, limit := range limits {
+		k.RestConfig.Wrap(limit)
 	}
 	k.Clientset, err = clientcore.NewForConfig(k.RestConfig)
 	if err != nil {
-		logger.Fatalf("can not create kubernetes clientset: %v", err)
-		return nil
+		return fmt.Errorf("can not create kubernetes clientset: %w", err)
+	}
+
+	k.Dynamic, err = dynamic.NewForConfig(k.RestConfig)
+	if err != nil {
+		return fmt.Errorf("can not create kubernetes dynamic client: %w", err)
+	}
+
+	k.Metadata, err = metadata.NewForConfig(k.RestConfig)
+	if err != nil {
+		return fmt.Errorf("can not create kubernetes metadata client: %w", err)
 	}
 
 	k.MetricsClientSet, err = clientmetrics.NewForConfig(k.RestConfig)
 	if err != nil {
-		logger.Fatalf("can not create kubernetes metric clientset: %v", err)
-		return nil
+		return fmt.Errorf("can not create kubernetes metric clientset: %w", err)
 	}
-	return &k
+	return nil
+}
+
+// WatchCredentials reloads changed credentials of the cluster until ctx is done
+func (k *K8s) WatchCredentials(ctx context.Context) {
+	if k.creds == nil || k.creds.settings.ReloadInterval <= 0 {
+		return
+	}
+	go k.creds.Watch(ctx)
 }
 
 // GetVersion returns the version of the kubernetes cluster that is running
@@ -58,6 +158,14 @@ func (k *K8s) SetNamespace(namesapce string) {
 	k.namesapce = namesapce
 }
 
+// WithNamespace returns a copy of k sharing its clientsets with namespace set,
+// so concurrent syncs of different namespaces do not race on SetNamespace
+func (k *K8s) WithNamespace(namesapce string) *K8s {
+	c := *k
+	c.namesapce = namesapce
+	return &c
+}
+
 func (k *K8s) GetNamespace() string {
 	if k.namesapce == "" {
 		logger.Warn("can not get current namespace, use 'default'")
@@ -91,23 +199,3 @@ func (k *K8s) GetCurNamespace() string {
 	}
 	return namespace
 }
-
-func (k *K8s) getRestConfig(cluster string) (*clientreset.Config, error) {
-	k.outOfCluster = true
-	kubeconfigPath := viper.GetString(cluster + ".kube-config")
-	if kubeconfigPath == "" {
-		kubeconfigPath = clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
-		if kubeconfigPath != "" {
-			logger.Infof("get %s cluster default config", cluster)
-		}
-	} else {
-		logger.Infof("get %s cluster config", cluster)
-	}
-	if kubeconfigPath == "" {
-		logger.Infof("use %s cluster internal config", cluster)
-		k.outOfCluster = false
-		return clientreset.InClusterConfig()
-	}
-	logger.Infof("use %s cluster out config %s", cluster, kubeconfigPath)
-	return clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// limitWrites delays create, update, patch and delete requests by a token bucket of qps and burst,
+// reads are only limited by the client QPS
+func limitWrites(qps float32, burst int) func(http.RoundTripper) http.RoundTripper {
+	limiter := flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				if err := limiter.Wait(req.Context()); err != nil {
+					return nil, err
+				}
+			}
+			return rt.RoundTrip(req)
+		})
+	}
+}
+
+// requestTimeout bounds each request including its response body by timeout,
+// watches are long running and not bounded
+func requestTimeout(timeout time.Duration) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if watch := req.URL.Query().Get("watch"); watch == "true" || watch == "1" {
+				return rt.RoundTrip(req)
+			}
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			resp, err := rt.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		})
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// cancelBody cancels the request context when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcore "k8s.io/client-go/kubernetes"
+	clientreset "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"k8sync/internal/config"
+	"k8sync/pkg/logger"
+)
+
+// loadRestConfig loads the rest config of a cluster from its settings,
+// inCluster reports whether the in-cluster config is used
+func loadRestConfig(c config.ClusterSettings) (rc *clientreset.Config, inCluster bool, err error) {
+	switch {
+	case c.Server != "":
+		rc = &clientreset.Config{
+			Host:            c.Server,
+			BearerToken:     c.Token,
+			BearerTokenFile: c.TokenFile, // re-read by client-go when it changes
+			TLSClientConfig: clientreset.TLSClientConfig{
+				Insecure: c.Insecure,
+				CAFile:   c.CaFile,
+			},
+		}
+		if c.CaData != "" {
+			rc.CAData = decodeData(c.CaData)
+		}
+		return rc, false, nil
+	case c.KubeConfigData != "":
+		rc, err = kubeconfigRestConfig(decodeData(c.KubeConfigData), c.Context)
+		return rc, false, err
+	case c.KubeConfigSecret.Name != "":
+		data, err := secretKubeconfig(c.KubeConfigSecret)
+		if err != nil {
+			return nil, false, err
+		}
+		rc, err = kubeconfigRestConfig(data, c.Context)
+		return rc, false, err
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if c.KubeConfig != "" {
+		rules.ExplicitPath = c.KubeConfig
+	} else if _, err = os.Stat(rules.GetDefaultFilename()); err != nil {
+		rc, err = clientreset.InClusterConfig()
+		return rc, true, err
+	}
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: c.Context})
+	rc, err = cc.ClientConfig()
+	return rc, false, err
+}
+
+// kubeconfigRestConfig returns the rest config of context in kubeconfig, the current context if empty
+func kubeconfigRestConfig(kubeconfig []byte, context string) (*clientreset.Config, error) {
+	kc, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig failed: %w", err)
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*kc, context, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+}
+
+// secretKubeconfig reads a kubeconfig from a Secret of the cluster k8sync runs in
+func secretKubeconfig(ref config.SecretRefSettings) ([]byte, error) {
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{})
+	rc, err := cc.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get config to read kubeconfig secret failed: %w", err)
+	}
+	ns := ref.Namespace
+	if ns == "" {
+		if ns = os.Getenv("POD_NAMESPACE"); ns == "" {
+			ns, _, _ = cc.Namespace()
+		}
+	}
+	cs, err := clientcore.NewForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secret, err := cs.CoreV1().Secrets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get kubeconfig secret %s/%s failed: %w", ns, ref.Name, err)
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig secret %s/%s has no key %s", ns, ref.Name, ref.Key)
+	}
+	return data, nil
+}
+
+// decodeData returns inline data, decoded when it is base64
+func decodeData(s string) []byte {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return b
+	}
+	return []byte(s)
+}
+
+// describeSource tells where the config of a cluster is loaded from
+func describeSource(c config.ClusterSettings) string {
+	switch {
+	case c.Server != "":
+		return "server " + c.Server
+	case c.KubeConfigData != "":
+		return "inline config"
+	case c.KubeConfigSecret.Name != "":
+		return fmt.Sprintf("config of secret %s/%s", c.KubeConfigSecret.Namespace, c.KubeConfigSecret.Name)
+	case c.KubeConfig != "":
+		return "out config " + c.KubeConfig
+	}
+	return "default config"
+}
+
+// credentials is the transport of a cluster, requests use the credentials of the latest
+// rest config loaded, so rotated certificates and tokens apply without a restart
+type credentials struct {
+	cluster  string
+	settings config.ClusterSettings
+
+	mu          sync.RWMutex
+	rt          http.RoundTripper
+	host        string
+	fingerprint [sha256.Size]byte
+}
+
+// newCredentials loads the rest config of a cluster, the returned config sends requests
+// through the credentials transport
+func newCredentials(cluster string, settings config.ClusterSettings) (*credentials, *clientreset.Config, bool, error) {
+	rc, inCluster, err := loadRestConfig(settings)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	c := &credentials{cluster: cluster, settings: settings}
+	if _, err = c.update(rc); err != nil {
+		return nil, nil, false, err
+	}
+	outer := clientreset.AnonymousClientConfig(rc)
+	outer.TLSClientConfig = clientreset.TLSClientConfig{}
+	outer.Transport = c
+	return c, outer, inCluster, nil
+}
+
+func (c *credentials) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.RLock()
+	rt := c.rt
+	c.mu.RUnlock()
+	return rt.RoundTrip(req)
+}
+
+// update replaces the transport when the credentials of rc changed
+func (c *credentials) update(rc *clientreset.Config) (bool, error) {
+	if err := clientreset.LoadTLSFiles(rc); err != nil {
+		return false, err
+	}
+	fingerprint := sha256.Sum256(bytes.Join([][]byte{
+		[]byte(rc.Host), []byte(rc.BearerToken), []byte(rc.BearerTokenFile),
+		[]byte(rc.Username), []byte(rc.Password),
+		rc.CAData, rc.CertData, rc.KeyData,
+		[]byte(fmt.Sprint(rc.Insecure, rc.ServerName)),
+	}, []byte{0}))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rt != nil && fingerprint == c.fingerprint {
+		return false, nil
+	}
+	if c.host != "" && rc.Host != c.host {
+		logger.Warnf("%s cluster server changed from %s to %s, restart to apply", c.cluster, c.host, rc.Host)
+	}
+	rt, err := clientreset.TransportFor(rc)
+	if err != nil {
+		return false, err
+	}
+	if c.host == "" {
+		c.host = rc.Host
+	}
+	c.rt, c.fingerprint = rt, fingerprint
+	return true, nil
+}
+
+// Reload loads the rest config again and applies changed credentials
+func (c *credentials) Reload() error {
+	rc, _, err := loadRestConfig(c.settings)
+	if err != nil {
+		return err
+	}
+	changed, err := c.update(rc)
+	if err != nil {
+		return err
+	}
+	if changed {
+		logger.Infof("%s cluster credentials reloaded", c.cluster)
+	}
+	return nil
+}
+
+// Watch reloads the credentials every reload interval until ctx is done,
+// a failed reload keeps the current credentials
+func (c *credentials) Watch(ctx context.Context) {
+	ticker := time.NewTicker(c.settings.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Reload(); err != nil {
+				logger.Warnf("reload %s cluster credentials failed: %s", c.cluster, err)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -12,6 +13,11 @@ import (
 	"k8sync/internal/k8s/client"
 	"k8sync/internal/k8s/handler"
 	"k8sync/internal/k8s/utils"
+	"k8sync/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -25,6 +31,8 @@ import (
 
 var serverStartTime time.Time
 
+var tracer = tracing.Tracer("controller")
+
 // Event indicate the informerEvent
 type Event struct {
 	key          string
@@ -42,10 +50,12 @@ type Controller struct {
 	queue        workqueue.TypedRateLimitingInterface[Event]
 	informer     cache.SharedIndexInformer
 	eventHandler handler.Handler
+	lastSync     atomic.Int64 // unix nano of last successful processed event
+	done         chan struct{}
 }
 
 // Start prepares watchers and run their controllers, then waits for process termination signals
-func Start(ctx context.Context, k8s *client.K8s, eventHandler handler.Handler) {
+func Start(ctx context.Context, k8s *client.K8s, eventHandler handler.Handler) *Controller {
 	var kubeClient kubernetes.Interface
 	var namespace string
 
@@ -67,7 +77,17 @@ func Start(ctx context.Context, k8s *client.K8s, eventHandler handler.Handler) {
 	)
 
 	rc := newResourceController(kubeClient, eventHandler, informer, "service")
+	if rc == nil {
+		logger.Error("create service controller failed")
+		return nil
+	}
+	go func() {
+		// wake the workers blocked on an empty queue
+		<-ctx.Done()
+		rc.queue.ShutDown()
+	}()
 	go rc.Run(ctx.Done())
+	return rc
 }
 
 func newResourceController(client kubernetes.Interface, eventHandler handler.Handler,
@@ -75,7 +95,8 @@ func newResourceController(client kubernetes.Interface, eventHandler handler.Han
 	var newEvent Event
 	var err error
 
-	queue := workqueue.NewTypedRateLimitingQueue[Event](workqueue.DefaultTypedControllerRateLimiter[Event]())
+	queue := workqueue.NewTypedRateLimitingQueueWithConfig[Event](workqueue.DefaultTypedControllerRateLimiter[Event](),
+		workqueue.TypedRateLimitingQueueConfig[Event]{Name: resourceType})
 	x, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			newEvent.key, err = cache.MetaNamespaceKeyFunc(obj)
@@ -120,11 +141,13 @@ func newResourceController(client kubernetes.Interface, eventHandler handler.Han
 		informer:     informer,
 		queue:        queue,
 		eventHandler: eventHandler,
+		done:         make(chan struct{}),
 	}
 }
 
 // Run starts the k8s controller
 func (c *Controller) Run(stopCh <-chan struct{}) {
+	defer close(c.done)
 	defer utilruntime.HandleCrash()
 	defer c.queue.ShutDown()
 
@@ -143,6 +166,11 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	wait.Until(c.runWorker, time.Second, stopCh)
 }
 
+// Done is closed when the controller stopped processing events
+func (c *Controller) Done() <-chan struct{} {
+	return c.done
+}
+
 // HasSynced is required for the cache.Controller interface.
 func (c *Controller) HasSynced() bool {
 	return c.informer.HasSynced()
@@ -153,6 +181,20 @@ func (c *Controller) LastSyncResourceVersion() string {
 	return c.informer.LastSyncResourceVersion()
 }
 
+// QueueLen returns the number of events waiting to be processed
+func (c *Controller) QueueLen() int {
+	return c.queue.Len()
+}
+
+// LastSyncTime returns the time of the last successful processed event,
+// zero time if none
+func (c *Controller) LastSyncTime() time.Time {
+	if ns := c.lastSync.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
 func (c *Controller) runWorker() {
 	for c.processNextItem() {
 		// continue looping
@@ -170,6 +212,7 @@ func (c *Controller) processNextItem() bool {
 	if err == nil {
 		// No error, reset the ratelimit counters
 		c.queue.Forget(newEvent)
+		c.lastSync.Store(time.Now().UnixNano())
 	} else if c.queue.NumRequeues(newEvent) < utils.MaxRetries {
 		logger.Errorf("processing %s failed (will retry): %v", newEvent.key, err)
 		c.queue.AddRateLimited(newEvent)
@@ -192,9 +235,20 @@ func (c *Controller) processNextItem() bool {
 func (c *Controller) processItem(ctlEvent Event) error {
 	// hold status type for default critical alerts
 	var status string
+	var kbEvent *handler.Event
+
+	_, span := tracer.Start(context.Background(), "controller.processItem", trace.WithAttributes(
+		attribute.String("k8sync.key", ctlEvent.key),
+		attribute.String("k8sync.event", ctlEvent.eventType),
+		attribute.String("k8sync.resource", ctlEvent.resourceType),
+		attribute.Int("k8sync.requeues", c.queue.NumRequeues(ctlEvent)),
+	))
+	defer span.End()
 
 	obj, _, err := c.informer.GetIndexer().GetByKey(ctlEvent.key)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
 		return fmt.Errorf("error fetching object with key %s from store: %w", ctlEvent.key, err)
 	}
 	// get object's metedata
@@ -225,9 +279,7 @@ func (c *Controller) processItem(ctlEvent Event) error {
 			default:
 				status = utils.StatusNormal
 			}
-			kbEvent := handler.New(obj, ctlEvent.namespace, ctlEvent.eventType, ctlEvent.resourceType, status)
-			c.eventHandler.Handle(kbEvent)
-			return nil
+			kbEvent = handler.New(obj, ctlEvent.namespace, ctlEvent.eventType, ctlEvent.resourceType, status)
 		}
 	case utils.EventTypeUpdate:
 		switch ctlEvent.resourceType {
@@ -236,16 +288,18 @@ func (c *Controller) processItem(ctlEvent Event) error {
 		default:
 			status = utils.StatusWarning
 		}
-		kbEvent := handler.New(obj, ctlEvent.namespace, ctlEvent.eventType, ctlEvent.resourceType, status)
-		c.eventHandler.Handle(kbEvent)
-		return nil
+		kbEvent = handler.New(obj, ctlEvent.namespace, ctlEvent.eventType, ctlEvent.resourceType, status)
 	case utils.EventTypeDelete:
 		if obj == nil {
 			obj = ctlEvent.oldObj
 		}
-		kbEvent := handler.New(obj, ctlEvent.namespace, ctlEvent.eventType, ctlEvent.resourceType, utils.StatusDanger)
-		c.eventHandler.Handle(kbEvent)
+		kbEvent = handler.New(obj, ctlEvent.namespace, ctlEvent.eventType, ctlEvent.resourceType, utils.StatusDanger)
+	}
+	if kbEvent == nil {
 		return nil
 	}
+	// every handler streams the event to the event watchers
+	handler.Events.Publish(kbEvent)
+	c.eventHandler.Handle(kbEvent)
 	return nil
 }
@@ -0,0 +1,473 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8sync/internal/k8s/apis/v1alpha1"
+	"k8sync/internal/k8s/client"
+	"k8sync/internal/k8s/utils"
+	"k8sync/internal/process"
+	"k8sync/pkg/logger"
+)
+
+// PolicyController reconciles SyncPolicy objects, each policy syncs in its own goroutine
+// until it is changed or deleted
+type PolicyController struct {
+	k8s      *client.K8s // cluster the policies and their kubeconfig Secrets are in
+	dynamic  dynamic.Interface
+	informer cache.SharedIndexInformer
+	queue    workqueue.TypedRateLimitingInterface[string]
+
+	mu      sync.Mutex
+	ctx     context.Context
+	runners map[string]*policyRunner // by namespace/name
+	done    chan struct{}
+}
+
+// policyRunner is the sync loop of one generation of a policy
+type policyRunner struct {
+	generation int64
+	cancel     context.CancelFunc
+	clusters   map[string]*policyCluster // kubeconfig clients by src or dst, used by the sync loop only
+}
+
+// policyCluster is a client of a kubeconfig Secret, built again when the Secret changes
+type policyCluster struct {
+	resourceVersion string
+	k8s             *client.K8s
+}
+
+// StartPolicies watches SyncPolicy objects in namespace, all namespaces if empty,
+// and syncs them until ctx is done
+func StartPolicies(ctx context.Context, k8s *client.K8s, namespace string) (*PolicyController, error) {
+	if k8s.Dynamic == nil {
+		return nil, fmt.Errorf("syncpolicy controller needs a dynamic client")
+	}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(k8s.Dynamic, 0, namespace, nil)
+	c := &PolicyController{
+		k8s:      k8s,
+		dynamic:  k8s.Dynamic,
+		informer: factory.ForResource(v1alpha1.SyncPolicyResource).Informer(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig[string](workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "syncpolicy"}),
+		ctx:     ctx,
+		runners: make(map[string]*policyRunner),
+		done:    make(chan struct{}),
+	}
+	_, err := c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			// status writes do not change the generation
+			if old.(*unstructured.Unstructured).GetGeneration() != new.(*unstructured.Unstructured).GetGeneration() {
+				c.enqueue(new)
+			}
+		},
+		DeleteFunc: c.enqueue,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+	go c.Run(ctx.Done())
+	return c, nil
+}
+
+// Covering returns the namespace/name of a policy, not suspended, syncing namespace of the cluster c is in,
+// empty if none
+func (c *PolicyController) Covering(namespace string) string {
+	for _, obj := range c.informer.GetIndexer().List() {
+		p, err := v1alpha1.FromUnstructured(obj.(*unstructured.Unstructured))
+		if err != nil || p.Spec.Suspend || p.Spec.Source.KubeconfigSecretRef != nil {
+			continue
+		}
+		srcNs := p.Spec.Source.Namespace
+		if srcNs == "" {
+			srcNs = p.Namespace
+		}
+		if srcNs == namespace {
+			return p.Namespace + "/" + p.Name
+		}
+	}
+	return ""
+}
+
+func (c *PolicyController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run starts the syncpolicy controller
+func (c *PolicyController) Run(stopCh <-chan struct{}) {
+	defer close(c.done)
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+	defer c.stopAll()
+
+	logger.Info("Starting syncpolicy controller")
+	go c.informer.Run(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for syncpolicy caches to sync"))
+		return
+	}
+	logger.Info("syncpolicy controller synced and ready")
+
+	wait.Until(c.runWorker, time.Second, stopCh)
+}
+
+// Done is closed when the controller stopped all policies
+func (c *PolicyController) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *PolicyController) runWorker() {
+	for c.processNextItem() {
+		// continue looping
+	}
+}
+
+func (c *PolicyController) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.reconcile(key)
+	if err == nil {
+		c.queue.Forget(key)
+	} else if c.queue.NumRequeues(key) < utils.MaxRetries {
+		logger.Errorf("reconcile syncpolicy %s failed (will retry): %v", key, err)
+		c.queue.AddRateLimited(key)
+	} else {
+		logger.Errorf("reconcile syncpolicy %s over max %d retries (giving up): %v", key, utils.MaxRetries, err)
+		c.queue.Forget(key)
+		utilruntime.HandleError(err)
+	}
+	return true
+}
+
+// reconcile restarts the sync loop of a policy when its generation changed,
+// and stops it when the policy is deleted
+func (c *PolicyController) reconcile(key string) error {
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("error fetching object with key %s from store: %w", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.runners[key]
+	if !exists {
+		if r != nil {
+			r.cancel()
+			delete(c.runners, key)
+			logger.Infof("syncpolicy %s deleted, stop syncing", key)
+		}
+		return nil
+	}
+
+	p, err := v1alpha1.FromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		if r.generation == p.Generation {
+			return nil
+		}
+		r.cancel()
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	r = &policyRunner{generation: p.Generation, cancel: cancel, clusters: make(map[string]*policyCluster)}
+	c.runners[key] = r
+	logger.Infof("syncpolicy %s generation %d, start syncing", key, p.Generation)
+	go c.run(ctx, r, p)
+	return nil
+}
+
+func (c *PolicyController) stopAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, r := range c.runners {
+		r.cancel()
+		delete(c.runners, key)
+	}
+}
+
+// run syncs p on its schedule until ctx is done
+func (c *PolicyController) run(ctx context.Context, r *policyRunner, p *v1alpha1.SyncPolicy) {
+	interval, err := validatePolicy(p)
+	if err != nil {
+		c.setCondition(ctx, p, v1alpha1.ConditionReady, metav1.ConditionFalse, "InvalidSpec", err.Error(), nil)
+		return
+	}
+	if p.Spec.Suspend {
+		c.setCondition(ctx, p, v1alpha1.ConditionReady, metav1.ConditionFalse, "Suspended", "sync is suspended", nil)
+		return
+	}
+	for {
+		c.sync(ctx, r, p, interval)
+		if interval == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// validatePolicy checks the spec and returns the sync interval
+func validatePolicy(p *v1alpha1.SyncPolicy) (time.Duration, error) {
+	for _, kind := range p.Spec.Kinds {
+		if !contains(process.SyncKinds, kind) {
+			return 0, fmt.Errorf("unsupported object kind %q", kind)
+		}
+	}
+	for _, r := range policyOptions(p).IgnoreDifferences {
+		if err := r.Validate(); err != nil {
+			return 0, fmt.Errorf("invalid ignoreDifferences rule: %w", err)
+		}
+	}
+	if v := p.Spec.Verify; v != nil && v.Timeout != "" {
+		timeout, err := time.ParseDuration(v.Timeout)
+		if err != nil {
+			return 0, fmt.Errorf("invalid verify timeout %q: %w", v.Timeout, err)
+		}
+		if timeout <= 0 {
+			return 0, fmt.Errorf("verify timeout %s is not positive", timeout)
+		}
+	}
+	for _, ref := range []*v1alpha1.SecretKeyRef{p.Spec.Source.KubeconfigSecretRef, p.Spec.Destination.KubeconfigSecretRef} {
+		if ref != nil && ref.Name == "" {
+			return 0, fmt.Errorf("kubeconfigSecretRef name is empty")
+		}
+	}
+	if p.Spec.Schedule == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(p.Spec.Schedule)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule %q: %w", p.Spec.Schedule, err)
+	}
+	if interval < time.Minute {
+		return 0, fmt.Errorf("schedule %s is shorter than 1m", interval)
+	}
+	return interval, nil
+}
+
+// sync runs one sync of p and writes its result to the status
+func (c *PolicyController) sync(ctx context.Context, r *policyRunner, p *v1alpha1.SyncPolicy, interval time.Duration) {
+	key := p.Namespace + "/" + p.Name
+	srcK8, err := c.cluster(ctx, r, p, "src", p.Spec.Source)
+	var dstK8 *client.K8s
+	if err == nil {
+		dstK8, err = c.cluster(ctx, r, p, "dst", p.Spec.Destination)
+	}
+	if err != nil {
+		logger.Errorf("syncpolicy %s: %s", key, err)
+		c.setCondition(ctx, p, v1alpha1.ConditionReady, metav1.ConditionFalse, "ClusterUnavailable", err.Error(), nil)
+		return
+	}
+
+	srcNs := p.Spec.Source.Namespace
+	if srcNs == "" {
+		srcNs = p.Namespace
+	}
+	dstNs := p.Spec.Destination.Namespace
+	if dstNs == "" {
+		dstNs = srcNs
+	}
+	kinds := p.Spec.Kinds
+	if len(kinds) == 0 {
+		kinds = []string{"deployment", "service"}
+	}
+
+	rec := process.NewSummary()
+	start := time.Now()
+	syncCtx, cancel := process.SyncContext(ctx)
+	defer cancel()
+	err = process.SyncNamespace(syncCtx, srcK8.WithNamespace(srcNs), dstK8.WithNamespace(dstNs), kinds, policyOptions(p), rec)
+	if ctx.Err() != nil {
+		// the policy changed or was deleted, the next runner writes the status
+		return
+	}
+	failures := rec.Failures()
+	result := &v1alpha1.SyncResult{
+		Succeeded: int32(rec.Succeeded()),
+		Failed:    int32(len(failures)),
+		Conflicts: int32(len(rec.Conflicts())),
+		Duration:  time.Since(start).Round(time.Millisecond).String(),
+	}
+	status, reason, msg := metav1.ConditionTrue, "Succeeded", fmt.Sprintf("%d objects synced", result.Succeeded)
+	if result.Conflicts > 0 {
+		msg += fmt.Sprintf(", %d with conflicts the source won", result.Conflicts)
+	}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+		status, reason, msg = metav1.ConditionFalse, "Failed", err.Error()
+		logger.Errorf("syncpolicy %s sync failed: %s", key, err)
+	case len(failures) > 0:
+		f := failures[0]
+		result.Error = fmt.Sprintf("%s %s %s failed: %s", f.Action, f.Kind, f.Name, f.Err)
+		status, reason = metav1.ConditionFalse, "ObjectsFailed"
+		msg = fmt.Sprintf("%d objects failed, first: %s", len(failures), result.Error)
+		logger.Errorf("syncpolicy %s synced %s to %s: %d succeeded, %d failed", key, srcNs, dstNs, result.Succeeded, result.Failed)
+	default:
+		logger.Infof("syncpolicy %s synced %s to %s: %d succeeded, %d failed", key, srcNs, dstNs, result.Succeeded, result.Failed)
+	}
+
+	c.setCondition(ctx, p, v1alpha1.ConditionSynced, status, reason, msg, func(st *v1alpha1.SyncPolicyStatus) {
+		now := metav1.Now()
+		st.LastSyncTime = &now
+		st.LastSyncResult = result
+		st.NextSyncTime = nil
+		if interval > 0 {
+			next := metav1.NewTime(now.Add(interval))
+			st.NextSyncTime = &next
+		}
+		meta.SetStatusCondition(&st.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionTrue,
+			Reason:             "Ready",
+			Message:            "both clusters are reachable",
+			ObservedGeneration: p.Generation,
+		})
+	})
+}
+
+// cluster returns the client of a policy cluster, the cluster of c unless a kubeconfig Secret is given.
+// the client of a Secret is kept by the runner of p until the Secret changes, so its limits last across syncs
+func (c *PolicyController) cluster(ctx context.Context, r *policyRunner, p *v1alpha1.SyncPolicy, name string,
+	ref v1alpha1.ClusterRef) (*client.K8s, error) {
+	if ref.KubeconfigSecretRef == nil {
+		return c.k8s, nil
+	}
+	secretKey := ref.KubeconfigSecretRef.Key
+	if secretKey == "" {
+		secretKey = v1alpha1.DefaultKubeconfigKey
+	}
+	secret, err := c.k8s.Clientset.CoreV1().Secrets(p.Namespace).Get(ctx, ref.KubeconfigSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get %s kubeconfig secret failed: %w", name, err)
+	}
+	if cached := r.clusters[name]; cached != nil && cached.resourceVersion == secret.ResourceVersion {
+		return cached.k8s, nil
+	}
+	data, ok := secret.Data[secretKey]
+	if !ok {
+		return nil, fmt.Errorf("%s kubeconfig secret %s has no key %s", name, secret.Name, secretKey)
+	}
+	k8s, err := client.NewFromKubeconfig(name, data)
+	if err != nil {
+		return nil, err
+	}
+	r.clusters[name] = &policyCluster{resourceVersion: secret.ResourceVersion, k8s: k8s}
+	return k8s, nil
+}
+
+// setCondition sets a condition and applies update to the latest status of p
+func (c *PolicyController) setCondition(ctx context.Context, p *v1alpha1.SyncPolicy, condType string,
+	status metav1.ConditionStatus, reason, msg string, update func(st *v1alpha1.SyncPolicyStatus)) {
+	res := c.dynamic.Resource(v1alpha1.SyncPolicyResource).Namespace(p.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := res.Get(ctx, p.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		latest, err := v1alpha1.FromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		if latest.Generation != p.Generation {
+			return nil // a newer runner owns the status
+		}
+		st := &latest.Status
+		st.ObservedGeneration = p.Generation
+		meta.SetStatusCondition(&st.Conditions, metav1.Condition{
+			Type:               condType,
+			Status:             status,
+			Reason:             reason,
+			Message:            msg,
+			ObservedGeneration: p.Generation,
+		})
+		if update != nil {
+			update(st)
+		}
+		if u.Object["status"], err = latest.StatusToUnstructured(); err != nil {
+			return err
+		}
+		_, err = res.UpdateStatus(ctx, u, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
+		logger.Errorf("update syncpolicy %s/%s status failed: %s", p.Namespace, p.Name, err)
+	}
+}
+
+// policyOptions converts the filters, transforms, ignore rules and verification of p
+func policyOptions(p *v1alpha1.SyncPolicy) *process.Options {
+	opts := &process.Options{Include: p.Spec.Include, Exclude: p.Spec.Exclude, Dependencies: p.Spec.Dependencies,
+		ThreeWayMerge: p.Spec.ThreeWayMerge}
+	for _, t := range p.Spec.Transforms {
+		pt := process.Transform{
+			Kinds:       t.Kinds,
+			Labels:      t.Labels,
+			Annotations: t.Annotations,
+			Replicas:    t.Replicas,
+		}
+		for _, img := range t.Images {
+			pt.Images = append(pt.Images, process.ImageRewrite{From: img.From, To: img.To})
+		}
+		opts.Transforms = append(opts.Transforms, pt)
+	}
+	if v := p.Spec.Verify; v != nil {
+		timeout, err := time.ParseDuration(v.Timeout)
+		if err != nil || v.Timeout == "" {
+			timeout = v1alpha1.DefaultVerifyTimeout
+		}
+		opts.Verify = &process.Verify{Timeout: timeout, Rollback: v.Rollback}
+	}
+	for _, r := range p.Spec.IgnoreDifferences {
+		opts.IgnoreDifferences = append(opts.IgnoreDifferences, process.IgnoreRule{
+			Kind:         r.Kind,
+			Name:         r.Name,
+			Selector:     r.Selector,
+			JSONPointers: r.JSONPointers,
+			JSONPaths:    r.JSONPaths,
+		})
+	}
+	return opts
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
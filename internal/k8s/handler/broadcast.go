@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"k8sync/pkg/logger"
+)
+
+// subscriptionBuffer is the number of events buffered for each subscriber
+const subscriptionBuffer = 100
+
+// Events broadcasts every handled event to its subscribers
+var Events = NewBroadcaster()
+
+// Filter selects events, an empty field matches everything
+type Filter struct {
+	Namespaces []string
+	Kinds      []string
+	Statuses   []string
+}
+
+// Match reports whether e is selected by f
+func (f Filter) Match(e *Event) bool {
+	return matchAny(f.Namespaces, e.Namespace) && matchAny(f.Kinds, e.Kind) && matchAny(f.Statuses, e.Status)
+}
+
+func matchAny(items []string, item string) bool {
+	if len(items) == 0 {
+		return true
+	}
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the matched events from C until it is unsubscribed
+type Subscription struct {
+	C       <-chan *Event
+	ch      chan *Event
+	filter  Filter
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of events dropped because the subscriber was too slow
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Broadcaster fans out events to subscribers without ever blocking the publisher,
+// events are dropped for a subscriber whose buffer is full
+type Broadcaster struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBroadcaster creates an empty broadcaster
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber of the events matched by f
+func (b *Broadcaster) Subscribe(f Filter) *Subscription {
+	ch := make(chan *Event, subscriptionBuffer)
+	s := &Subscription{C: ch, ch: ch, filter: f}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe removes the subscriber and closes its channel
+func (b *Broadcaster) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish sends e to all matched subscribers
+func (b *Broadcaster) Publish(e *Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			if s.dropped.Add(1) == 1 {
+				logger.Warnf("event subscriber is too slow, drop events")
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	apiV1 "k8s.io/api/core/v1"
 	"k8sync/internal/k8s/utils"
@@ -18,6 +19,7 @@ type Event struct {
 	Reason    string
 	Status    string
 	Name      string
+	Time      time.Time
 	Obj       interface{}
 }
 
@@ -53,6 +55,7 @@ func New(obj interface{}, ns string, action string, rtype string, status string)
 		Reason:    reason,
 		Status:    status,
 		Name:      name,
+		Time:      time.Now(),
 		Obj:       obj,
 	}
 	return &kbEvent
@@ -96,6 +99,12 @@ func (e *Event) Message() (msg string) {
 			"Node `%s` Rebooted : \nNodeRebooted",
 			e.Name,
 		)
+	case "config":
+		msg = fmt.Sprintf(
+			"Config `%s` is invalid and has been `%s`",
+			e.Name,
+			e.Reason,
+		)
 	case "Backoff":
 		msg = fmt.Sprintf(
 			"Pod `%s` in `%s` Crashed : \nCrashLoopBackOff %s",
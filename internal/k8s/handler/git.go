@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"k8sync/internal/config"
+	"k8sync/internal/gitops"
+	"k8sync/internal/k8s/utils"
+	"k8sync/pkg/logger"
+)
+
+// Git handler implements Handler interface,
+// write each changed object into a local git working tree
+type Git struct {
+	repo *gitops.Repo
+}
+
+// Init opens the git working tree configured by dst.git.*
+func (g *Git) Init(c *config.Config) error {
+	var err error
+	git := config.Current().Dst.Git
+	g.repo, err = gitops.Open(git.Path, git.AuthorName, git.AuthorEmail)
+	return err
+}
+
+// Handle writes or removes the event object, then commits the change
+func (g *Git) Handle(e *Event) {
+	var err error
+	if e.Reason == utils.EventTypeDelete {
+		err = g.repo.Remove(e.Namespace, e.Kind, e.Name)
+	} else if obj, ok := e.Obj.(runtime.Object); ok {
+		err = g.repo.Write(e.Namespace, e.Kind, obj)
+	} else {
+		logger.Warnf("git handler skip %s %s/%s: unknown object %T", e.Kind, e.Namespace, e.Name, e.Obj)
+		return
+	}
+	if err != nil {
+		logger.Errorf("git handler save %s %s/%s failed: %s", e.Kind, e.Namespace, e.Name, err)
+		return
+	}
+	if err = g.repo.Commit(); err != nil {
+		logger.Errorf("git handler commit failed: %s", err)
+	}
+}
+
+func (g *Git) Clean() {
+	if g.repo == nil {
+		return
+	}
+	if err := g.repo.Commit(); err != nil {
+		logger.Errorf("git handler commit failed: %s", err)
+	}
+}
@@ -16,6 +16,7 @@ type Handler interface {
 // Map maps each event handler function to a name for easily lookup
 var Map = map[string]Handler{
 	"default": &Default{},
+	"git":     &Git{},
 }
 
 // Default handler implements Handler interface,
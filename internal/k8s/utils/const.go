@@ -1,11 +1,14 @@
 package utils
 
 const (
-	MaxRetries      = 5
-	EventTypeCreate = "create"
-	EventTypeUpdate = "update"
-	EventTypeDelete = "delete"
-	StatusDanger    = "Danger"
-	StatusWarning   = "Warning"
-	StatusNormal    = "Normal"
+	MaxRetries        = 5
+	EventTypeCreate   = "create"
+	EventTypeUpdate   = "update"
+	EventTypeDelete   = "delete"
+	EventTypeRecreate = "recreate"
+	EventTypeVerify   = "verify"
+	EventTypeRollback = "rollback"
+	StatusDanger      = "Danger"
+	StatusWarning     = "Warning"
+	StatusNormal      = "Normal"
 )
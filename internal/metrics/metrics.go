@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const namespace = "k8sync"
+
+// Registry holds all k8sync metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// SyncObjects counts objects applied to the destination by kind and action
+	SyncObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_objects_total",
+		Help:      "Number of objects created, updated or deleted in the destination.",
+	}, []string{"kind", "action"})
+
+	// SyncErrors counts failed object applies by kind and action
+	SyncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_errors_total",
+		Help:      "Number of failed object creates, updates or deletes in the destination.",
+	}, []string{"kind", "action"})
+
+	// ReconcileDuration observes the time to reconcile all objects of a kind
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time to reconcile all objects of a kind in a namespace.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"kind"})
+
+	// Drift is the number of objects which differ between source and destination
+	// found by the last reconcile
+	Drift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "drift_objects",
+		Help:      "Number of objects differing between source and destination at the last reconcile.",
+	}, []string{"namespace", "kind"})
+
+	// APIRequests counts api server requests by cluster, method and status code
+	APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Number of api server requests.",
+	}, []string{"cluster", "method", "code"})
+
+	// APIRequestDuration observes api server request latency by cluster and method
+	APIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of api server requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cluster", "method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SyncObjects,
+		SyncErrors,
+		ReconcileDuration,
+		Drift,
+		APIRequests,
+		APIRequestDuration,
+	)
+	registerWorkqueue()
+}
+
+// Handler serves the metrics in prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveSync counts the result of applying one object
+func ObserveSync(kind, action string, err error) {
+	if err != nil {
+		SyncErrors.WithLabelValues(kind, action).Inc()
+		return
+	}
+	SyncObjects.WithLabelValues(kind, action).Inc()
+}
+
+// ObserveReconcile observes the time spent reconciling the objects of kind
+func ObserveReconcile(kind string, elapsed time.Duration) {
+	ReconcileDuration.WithLabelValues(kind).Observe(elapsed.Seconds())
+}
+
+// InstrumentTransport returns a rest.Config WrapTransport function
+// recording the api server requests of cluster
+func InstrumentTransport(cluster string) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := rt.RoundTrip(req)
+			APIRequestDuration.WithLabelValues(cluster, req.Method).Observe(time.Since(start).Seconds())
+			code := "error"
+			if err == nil {
+				code = strconv.Itoa(resp.StatusCode)
+			}
+			APIRequests.WithLabelValues(cluster, req.Method, code).Inc()
+			return resp, err
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// workqueue metrics, the same names as the kubernetes controllers
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Current depth of workqueue.",
+	}, []string{"name"})
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "workqueue",
+		Name:      "adds_total",
+		Help:      "Total number of adds handled by workqueue.",
+	}, []string{"name"})
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      "queue_duration_seconds",
+		Help:      "How long in seconds an item stays in workqueue before being requested.",
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	queueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      "work_duration_seconds",
+		Help:      "How long in seconds processing an item from workqueue takes.",
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	queueUnfinished = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "unfinished_work_seconds",
+		Help:      "How many seconds of work has been done that is in progress and hasn't been observed by work_duration.",
+	}, []string{"name"})
+	queueLongestRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "longest_running_processor_seconds",
+		Help:      "How many seconds has the longest running processor for workqueue been running.",
+	}, []string{"name"})
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Total number of retries handled by workqueue.",
+	}, []string{"name"})
+)
+
+func registerWorkqueue() {
+	Registry.MustRegister(queueDepth, queueAdds, queueLatency, queueWorkDuration,
+		queueUnfinished, queueLongestRunning, queueRetries)
+	workqueue.SetProvider(workqueueProvider{})
+}
+
+// workqueueProvider implements workqueue.MetricsProvider
+type workqueueProvider struct{}
+
+func (workqueueProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (workqueueProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (workqueueProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return queueLatency.WithLabelValues(name)
+}
+
+func (workqueueProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return queueWorkDuration.WithLabelValues(name)
+}
+
+func (workqueueProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueUnfinished.WithLabelValues(name)
+}
+
+func (workqueueProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueLongestRunning.WithLabelValues(name)
+}
+
+func (workqueueProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
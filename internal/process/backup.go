@@ -0,0 +1,190 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"k8sync/gen/proto/k8sync/v1"
+	"k8sync/internal/backup"
+	"k8sync/internal/config"
+	"k8sync/internal/crypt"
+	"k8sync/internal/gitops"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/pkg/logger"
+)
+
+// Backup implements the protobuf interface
+type Backup struct {
+	pb.UnimplementedBackupServiceServer
+	store *backup.Store
+}
+
+// NewBackup initializes a new Backup struct with the configured snapshot store.
+func NewBackup() *Backup {
+	return &Backup{
+		store: NewBackupStore(),
+	}
+}
+
+// NewBackupStore returns the snapshot store configured by backup.*
+func NewBackupStore() *backup.Store {
+	settings := config.Current().Backup
+	return backup.NewStore(settings.Path, settings.Compress)
+}
+
+// ListSnapshots lists all saved snapshots, newest first
+func (b *Backup) ListSnapshots(ctx context.Context, req *pb.ListSnapshotsRequest) (*pb.ListSnapshotsResponse, error) {
+	snaps, err := b.store.List()
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListSnapshotsResponse{}
+	for _, snap := range snaps {
+		resp.Snapshots = append(resp.Snapshots, &pb.Snapshot{
+			Id:         snap.ID,
+			Namespace:  snap.Namespace,
+			Created:    timestamppb.New(snap.Created),
+			Objects:    int32(snap.Objects),
+			Compressed: snap.Compressed,
+		})
+	}
+	return resp, nil
+}
+
+// Snapshot saves all objs kinds in the source namespace into store
+func Snapshot(ctx context.Context, srcK8 *k8client.K8s, store *backup.Store, objs []string) (*backup.Snapshot, error) {
+	files := make(map[string][]byte)
+	for _, kind := range objs {
+		err := eachObject(ctx, srcK8, kind, func(item runtime.Object) error {
+			name := item.(metav1.Object).GetName()
+			data, err := gitops.Manifest(item)
+			if err != nil {
+				return fmt.Errorf("snapshot %s %s failed: %w", kind, name, err)
+			}
+			files[path.Join(kind, name+".yaml")] = data
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store.Save(srcK8.GetNamespace(), files)
+}
+
+// RunBackup snapshots the source namespace every interval and applies
+// the retention rules, until ctx is done
+func RunBackup(ctx context.Context, srcK8 *k8client.K8s, store *backup.Store, objs []string,
+	interval time.Duration, keep int, maxAge time.Duration) {
+	if interval <= 0 {
+		logger.Errorf("invalid backup interval: %s", interval)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		snapCtx, cancel := SyncContext(ctx)
+		snap, err := Snapshot(snapCtx, srcK8, store, objs)
+		cancel()
+		if err != nil {
+			logger.Errorf("backup namespace %s failed: %s", srcK8.GetNamespace(), err)
+		} else {
+			logger.Infof("backup namespace %s to snapshot %s, %d objects", snap.Namespace, snap.ID, snap.Objects)
+		}
+		removed, err := store.Prune(keep, maxAge)
+		if err != nil {
+			logger.Errorf("prune snapshots failed: %s", err)
+		}
+		for _, id := range removed {
+			logger.Infof("remove expired snapshot %s", id)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Restore replays the objs kinds of snapshot id into the destination, objects failed are recorded in rec only
+func Restore(ctx context.Context, store *backup.Store, id string, dstK8 *k8client.K8s, objs []string, rec Recorder) error {
+	snap, files, err := store.Load(id)
+	if err != nil {
+		return err
+	}
+	logger.Infof("restore snapshot %s of namespace %s, created at %s", snap.ID, snap.Namespace, snap.Created)
+
+	var deploys []appsv1.Deployment
+	var services []corev1.Service
+	var secrets []corev1.Secret
+	var configMaps []corev1.ConfigMap
+	var accounts []corev1.ServiceAccount
+	var claims []corev1.PersistentVolumeClaim
+	decoder := scheme.Codecs.UniversalDeserializer()
+	for name, data := range files {
+		kind := strings.SplitN(name, "/", 2)[0]
+		if !contains(objs, kind) {
+			continue
+		}
+		if data, err = crypt.DecryptManifest(data); err != nil {
+			return fmt.Errorf("decrypt %s failed: %w", name, err)
+		}
+		obj, _, err := decoder.Decode(data, nil, nil)
+		if err != nil {
+			return fmt.Errorf("decode %s failed: %w", name, err)
+		}
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			deploys = append(deploys, *o)
+		case *corev1.Service:
+			services = append(services, *o)
+		case *corev1.Secret:
+			secrets = append(secrets, *o)
+		case *corev1.ConfigMap:
+			configMaps = append(configMaps, *o)
+		case *corev1.ServiceAccount:
+			accounts = append(accounts, *o)
+		case *corev1.PersistentVolumeClaim:
+			claims = append(claims, *o)
+		default:
+			return fmt.Errorf("unsupported object %T in %s", obj, name)
+		}
+	}
+
+	var syncers []*kindSyncer
+	for _, kind := range objs {
+		switch kind {
+		case "service":
+			syncers = append(syncers, serviceSyncer(snap.Namespace, sliceSource(services), sliceWaves(services, nil), dstK8, nil, rec))
+		case "deployment":
+			syncers = append(syncers, deploymentSyncer(snap.Namespace, sliceSource(deploys), sliceWaves(deploys, nil), dstK8, nil, rec))
+		case "secret":
+			syncers = append(syncers, secretSyncer(snap.Namespace, sliceSource(secrets), sliceWaves(secrets, nil), dstK8, nil, rec))
+		case "configmap":
+			syncers = append(syncers, configMapSyncer(snap.Namespace, sliceSource(configMaps), sliceWaves(configMaps, nil), dstK8, nil, rec))
+		case "serviceaccount":
+			syncers = append(syncers, serviceAccountSyncer(snap.Namespace, sliceSource(accounts), sliceWaves(accounts, nil), dstK8, nil, rec))
+		case "persistentvolumeclaim":
+			syncers = append(syncers, claimSyncer(snap.Namespace, sliceSource(claims), sliceWaves(claims, nil), dstK8, nil, rec))
+		}
+	}
+	return syncWaves(ctx, snap.Namespace, syncers...)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package process
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/pkg/logger"
+)
+
+// Reference is an object a source deployment depends on
+type Reference struct {
+	From     string // deployment name
+	Kind     string
+	Name     string
+	Optional bool // the pod starts without the object
+}
+
+// ReferenceRecorder is a Recorder which also reports the references missing in the source
+type ReferenceRecorder interface {
+	RecordDangling(ref Reference)
+}
+
+// dependencies finds the objects referenced by the pod templates of the source deployments selected by opts,
+// and the services selecting their pods. it returns the names of the referenced objects found in the source
+// by kind, and the references not found
+func dependencies(ctx context.Context, srcK8 *k8client.K8s, opts *Options) (map[string]map[string]bool, []Reference, error) {
+	var refs []Reference
+	podLabels := make(map[string]labels.Set) // deployment -> pod template labels
+	err := pagedDeployments(srcK8)(ctx, func(d *appsv1.Deployment) error {
+		if opts.selected(d.Name) {
+			refs = append(refs, podReferences(d.Name, &d.Spec.Template.Spec)...)
+			podLabels[d.Name] = d.Spec.Template.Labels
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("list deployments failed: %w", err)
+	}
+	if len(podLabels) == 0 {
+		return nil, nil, nil
+	}
+
+	deps := make(map[string]map[string]bool)
+	add := func(kind, name string) {
+		if deps[kind] == nil {
+			deps[kind] = make(map[string]bool)
+		}
+		deps[kind][name] = true
+	}
+	err = pagedServices(srcK8)(ctx, func(s *corev1.Service) error {
+		if len(s.Spec.Selector) == 0 {
+			return nil
+		}
+		selector := labels.SelectorFromSet(s.Spec.Selector)
+		for _, l := range podLabels {
+			if selector.Matches(l) {
+				add("service", s.Name)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("list services failed: %w", err)
+	}
+
+	/* keep the references found in the source */
+	wanted := make(map[string]map[string]bool)
+	for _, ref := range refs {
+		if wanted[ref.Kind] == nil {
+			wanted[ref.Kind] = make(map[string]bool)
+		}
+		wanted[ref.Kind][ref.Name] = true
+	}
+	resources := map[string]schema.GroupVersionResource{
+		"configmap":             configMapResource,
+		"secret":                secretResource,
+		"serviceaccount":        accountResource,
+		"persistentvolumeclaim": claimResource,
+	}
+	for kind, names := range wanted {
+		err = eachMetadata(ctx, srcK8, resources[kind], "", func(m *metav1.PartialObjectMetadata) {
+			if names[m.Name] {
+				add(kind, m.Name)
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var dangling []Reference
+	for _, ref := range refs {
+		if !ref.Optional && !deps[ref.Kind][ref.Name] {
+			dangling = append(dangling, ref)
+		}
+	}
+	return deps, dangling, nil
+}
+
+// podReferences returns the config maps, secrets, service account and claims referenced by the pod spec of deployment from
+func podReferences(from string, spec *corev1.PodSpec) []Reference {
+	var refs []Reference
+	add := func(kind, name string, optional *bool) {
+		if name != "" {
+			refs = append(refs, Reference{From: from, Kind: kind, Name: name, Optional: optional != nil && *optional})
+		}
+	}
+	if spec.ServiceAccountName != "" && spec.ServiceAccountName != defaultServiceAccount {
+		add("serviceaccount", spec.ServiceAccountName, nil)
+	}
+	for _, s := range spec.ImagePullSecrets {
+		add("secret", s.Name, nil)
+	}
+	for _, v := range spec.Volumes {
+		switch {
+		case v.ConfigMap != nil:
+			add("configmap", v.ConfigMap.Name, v.ConfigMap.Optional)
+		case v.Secret != nil:
+			add("secret", v.Secret.SecretName, v.Secret.Optional)
+		case v.PersistentVolumeClaim != nil:
+			add("persistentvolumeclaim", v.PersistentVolumeClaim.ClaimName, nil)
+		case v.Projected != nil:
+			for _, p := range v.Projected.Sources {
+				if p.ConfigMap != nil {
+					add("configmap", p.ConfigMap.Name, p.ConfigMap.Optional)
+				}
+				if p.Secret != nil {
+					add("secret", p.Secret.Name, p.Secret.Optional)
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container(nil), spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, e := range c.EnvFrom {
+			if e.ConfigMapRef != nil {
+				add("configmap", e.ConfigMapRef.Name, e.ConfigMapRef.Optional)
+			}
+			if e.SecretRef != nil {
+				add("secret", e.SecretRef.Name, e.SecretRef.Optional)
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+			if r := e.ValueFrom.ConfigMapKeyRef; r != nil {
+				add("configmap", r.Name, r.Optional)
+			}
+			if r := e.ValueFrom.SecretKeyRef; r != nil {
+				add("secret", r.Name, r.Optional)
+			}
+		}
+	}
+	return refs
+}
+
+// withDependencies returns the options of each kind when the dependencies of the synced deployments
+// are synced too, a referenced object is selected even when Include misses it, Exclude still applies.
+// kinds only synced as dependencies are added to kinds, with only the referenced objects selected
+func withDependencies(ctx context.Context, srcK8 *k8client.K8s, kinds []string, opts *Options, rec Recorder) ([]string, map[string]*Options, error) {
+	kindOpts := make(map[string]*Options)
+	if opts == nil || !opts.Dependencies || !contains(kinds, "deployment") {
+		return kinds, kindOpts, nil
+	}
+	deps, dangling, err := dependencies(ctx, srcK8, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("find dependencies in %s failed: %w", srcK8.GetNamespace(), err)
+	}
+	for _, ref := range dangling {
+		logger.Warnf("deployment %s references %s %s which is not in namespace %s", ref.From, ref.Kind, ref.Name, srcK8.GetNamespace())
+		if rr, ok := rec.(ReferenceRecorder); ok {
+			rr.RecordDangling(ref)
+		}
+	}
+	all := append([]string(nil), kinds...)
+	for kind, names := range deps {
+		o := *opts
+		o.names = names
+		if !contains(kinds, kind) {
+			o.Include, o.onlyNames = nil, true
+			all = append(all, kind)
+			logger.Infof("sync %d %s as dependencies", len(names), kind)
+		}
+		kindOpts[kind] = &o
+	}
+	return all, kindOpts, nil
+}
@@ -0,0 +1,153 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"k8sync/pkg/logger"
+)
+
+// checkedAnnotation holds the time of the newest change other field managers made to a destination object
+// which k8sync compared with the source and found no drift
+const checkedAnnotation = "k8sync.io/checked-at"
+
+// controllerAnnotations are set on destination objects by their controllers, they are not drift
+var controllerAnnotations = []string{"deployment.kubernetes.io/revision"}
+
+// drifted reports whether the destination object dst no longer has a field of the filtered and transformed
+// source object obj, fields ignored by rules and fields only set in dst, like defaults, are not compared
+func drifted(obj, dst runtime.Object, rules []IgnoreRule) (bool, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+	du, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dst)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range []map[string]interface{}{u, du} {
+		sanitizeSnapshot(m)
+		if meta, ok := m["metadata"].(map[string]interface{}); ok {
+			if annotations, ok := meta["annotations"].(map[string]interface{}); ok {
+				for _, k := range controllerAnnotations {
+					delete(annotations, k)
+				}
+			}
+		}
+		if err = stripIgnored(m, rules); err != nil {
+			return false, err
+		}
+	}
+	return !containsValue(du, true, u), nil
+}
+
+// containsValue tells whether the destination value d contains the source value s: objects contain the fields
+// of s, lists have as many items each containing the item of s, other values are equal. a field missing in d
+// is contained when s is empty
+func containsValue(d interface{}, dok bool, s interface{}) bool {
+	if !dok {
+		return emptyValue(s)
+	}
+	switch sv := s.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		dm, ok := d.(map[string]interface{})
+		if !ok {
+			return len(sv) == 0 && d == nil
+		}
+		for k, v := range sv {
+			dv, ok := dm[k]
+			if !containsValue(dv, ok, v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		dl, ok := d.([]interface{})
+		if !ok {
+			return len(sv) == 0 && d == nil
+		}
+		if len(dl) != len(sv) {
+			return false
+		}
+		for i := range sv {
+			if !containsValue(dl[i], true, sv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return equality.Semantic.DeepEqual(d, s)
+}
+
+// emptyValue tells whether the unstructured value v is unset, empty or zero
+func emptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
+
+// othersChange returns the time of the newest change other field managers made to an object after
+// k8sync last wrote it, zero when there is none. status updates are not changes. managed fields times
+// have a precision of a second, a change in the same second as the write of k8sync is not seen
+func othersChange(entries []metav1.ManagedFieldsEntry) time.Time {
+	var ours, theirs time.Time
+	for _, e := range entries {
+		if e.Subresource == "status" || e.Time == nil {
+			continue
+		}
+		switch t := e.Time.Time; {
+		case e.Manager == fieldManager:
+			if t.After(ours) {
+				ours = t
+			}
+		case t.After(theirs):
+			theirs = t
+		}
+	}
+	if theirs.After(ours) {
+		return theirs
+	}
+	return time.Time{}
+}
+
+// acknowledge stamps the time of the changes other field managers made to the destination object dst,
+// found not to drift, so that the write makes k8sync the last manager and the next sync does not fetch dst
+func acknowledge[PT metav1.Object](ctx context.Context, client objectClient[PT], dst PT) {
+	changed := othersChange(dst.GetManagedFields())
+	if changed.IsZero() {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{checkedAnnotation: changed.UTC().Format(time.RFC3339)},
+		},
+	})
+	if err == nil {
+		_, err = client.Patch(ctx, dst.GetName(), types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	}
+	if err != nil {
+		logger.Warnf("  stamp %s of %s failed, it is compared again next sync: %s", checkedAnnotation, dst.GetName(), err)
+	}
+}
@@ -0,0 +1,208 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainsValue(t *testing.T) {
+	cases := []struct {
+		name string
+		d    interface{}
+		dok  bool
+		s    interface{}
+		want bool
+	}{
+		{name: "equal scalars", d: "a", dok: true, s: "a", want: true},
+		{name: "different scalars", d: "a", dok: true, s: "b", want: false},
+		{name: "numbers", d: int64(2), dok: true, s: int64(2), want: true},
+		{name: "missing empty string", s: "", want: true},
+		{name: "missing zero", s: int64(0), want: true},
+		{name: "missing false", s: false, want: true},
+		{name: "missing empty map", s: map[string]interface{}{}, want: true},
+		{name: "missing value", s: "a", want: false},
+		{name: "nil source", d: "a", dok: true, s: nil, want: true},
+		{
+			name: "destination only fields",
+			d:    map[string]interface{}{"a": "1", "b": "2"},
+			dok:  true,
+			s:    map[string]interface{}{"a": "1"},
+			want: true,
+		},
+		{
+			name: "changed field",
+			d:    map[string]interface{}{"a": "2"},
+			dok:  true,
+			s:    map[string]interface{}{"a": "1"},
+			want: false,
+		},
+		{
+			name: "list items contain source items",
+			d:    []interface{}{map[string]interface{}{"name": "a", "image": "x", "tty": false}},
+			dok:  true,
+			s:    []interface{}{map[string]interface{}{"name": "a", "image": "x"}},
+			want: true,
+		},
+		{
+			name: "list item added",
+			d:    []interface{}{"a", "b"},
+			dok:  true,
+			s:    []interface{}{"a"},
+			want: false,
+		},
+		{
+			name: "list items reordered",
+			d:    []interface{}{"b", "a"},
+			dok:  true,
+			s:    []interface{}{"a", "b"},
+			want: false,
+		},
+		{name: "map replaced by scalar", d: "a", dok: true, s: map[string]interface{}{"a": "1"}, want: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := containsValue(c.d, c.dok, c.s); got != c.want {
+				t.Errorf("containsValue(%v, %v, %v) = %v, want %v", c.d, c.dok, c.s, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDrifted(t *testing.T) {
+	src := testDeployment("", "nginx:1.27")
+	deployFilter(src)
+	metav1.SetMetaDataAnnotation(&src.ObjectMeta, sourceHashAnnotation, "h")
+	sidecar := corev1.Container{Name: "istio-proxy", Image: "proxy"}
+
+	cases := []struct {
+		name  string
+		edit  func(d *appsv1.Deployment)
+		rules []IgnoreRule
+		want  bool
+	}{
+		{name: "same", edit: func(d *appsv1.Deployment) {}},
+		{
+			name: "defaults and status",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.RevisionHistoryLimit = new(int32)
+				d.Status.ReadyReplicas = 1
+				d.UID, d.ResourceVersion = "uid", "7"
+			},
+		},
+		{
+			name: "revision annotation",
+			edit: func(d *appsv1.Deployment) {
+				metav1.SetMetaDataAnnotation(&d.ObjectMeta, "deployment.kubernetes.io/revision", "9")
+			},
+		},
+		{
+			name: "image",
+			edit: func(d *appsv1.Deployment) { d.Spec.Template.Spec.Containers[0].Image = "nginx:edited" },
+			want: true,
+		},
+		{
+			name: "label removed",
+			edit: func(d *appsv1.Deployment) { d.Labels = nil },
+			want: true,
+		},
+		{
+			name: "replicas",
+			edit: func(d *appsv1.Deployment) { *d.Spec.Replicas = 5 },
+			want: true,
+		},
+		{
+			name:  "ignored replicas",
+			edit:  func(d *appsv1.Deployment) { *d.Spec.Replicas = 5 },
+			rules: []IgnoreRule{{JSONPointers: []string{"/spec/replicas"}}},
+		},
+		{
+			name: "injected sidecar",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, sidecar)
+			},
+			want: true,
+		},
+		{
+			name: "ignored sidecar",
+			edit: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, sidecar)
+			},
+			rules: []IgnoreRule{{JSONPaths: []string{`{.spec.template.spec.containers[?(@.name=="istio-proxy")]}`}}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dst := src.DeepCopy()
+			dst.Namespace = "dst"
+			c.edit(dst)
+			got, err := drifted(src.DeepCopy(), dst, c.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("drifted = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestOthersChange(t *testing.T) {
+	at := func(sec int) *metav1.Time {
+		t := metav1.Unix(int64(1700000000+sec), 0)
+		return &t
+	}
+	entry := func(manager string, sec int, subresource string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{Manager: manager, Operation: metav1.ManagedFieldsOperationUpdate, Time: at(sec), Subresource: subresource}
+	}
+	cases := []struct {
+		name    string
+		entries []metav1.ManagedFieldsEntry
+		want    *metav1.Time
+	}{
+		{name: "no managed fields"},
+		{name: "only k8sync", entries: []metav1.ManagedFieldsEntry{entry(fieldManager, 10, "")}},
+		{
+			name:    "older change of others",
+			entries: []metav1.ManagedFieldsEntry{entry("kubectl-edit", 5, ""), entry(fieldManager, 10, "")},
+		},
+		{
+			name:    "newer change of others",
+			entries: []metav1.ManagedFieldsEntry{entry(fieldManager, 10, ""), entry("kubectl-edit", 12, ""), entry("helm", 11, "")},
+			want:    at(12),
+		},
+		{
+			name:    "same second",
+			entries: []metav1.ManagedFieldsEntry{entry(fieldManager, 10, ""), entry("kube-controller-manager", 10, "")},
+		},
+		{
+			name:    "status update",
+			entries: []metav1.ManagedFieldsEntry{entry(fieldManager, 10, ""), entry("kube-controller-manager", 12, "status")},
+		},
+		{
+			name:    "scale update",
+			entries: []metav1.ManagedFieldsEntry{entry(fieldManager, 10, ""), entry("hpa", 12, "scale")},
+			want:    at(12),
+		},
+		{
+			name:    "never written by k8sync",
+			entries: []metav1.ManagedFieldsEntry{entry("kubectl-create", 3, "")},
+			want:    at(3),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := othersChange(c.entries)
+			want := time.Time{}
+			if c.want != nil {
+				want = c.want.Time
+			}
+			if !got.Equal(want) {
+				t.Errorf("othersChange = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package process
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"k8sync/gen/proto/k8sync/v1"
+	"k8sync/internal/k8s/handler"
+	"k8sync/pkg/logger"
+)
+
+// Events implements the protobuf interface
+type Events struct {
+	pb.UnimplementedEventServiceServer
+	broadcaster *handler.Broadcaster
+}
+
+// NewEvents initializes a new Events struct streaming from broadcaster.
+func NewEvents(broadcaster *handler.Broadcaster) *Events {
+	return &Events{
+		broadcaster: broadcaster,
+	}
+}
+
+// Subscribe registers a subscriber of the events matched by req
+func (e *Events) Subscribe(req *pb.WatchEventsRequest) *handler.Subscription {
+	return e.broadcaster.Subscribe(handler.Filter{
+		Namespaces: req.GetNamespaces(),
+		Kinds:      req.GetKinds(),
+		Statuses:   req.GetStatuses(),
+	})
+}
+
+// Unsubscribe removes the subscriber
+func (e *Events) Unsubscribe(sub *handler.Subscription) {
+	if dropped := sub.Dropped(); dropped > 0 {
+		logger.Warnf("event subscriber dropped %d events", dropped)
+	}
+	e.broadcaster.Unsubscribe(sub)
+}
+
+// WatchEvents streams the matched events until the client goes away
+func (e *Events) WatchEvents(req *pb.WatchEventsRequest, stream pb.EventService_WatchEventsServer) error {
+	sub := e.Subscribe(req)
+	defer e.Unsubscribe(sub)
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(EventToPB(ev)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// EventToPB converts a handler event into its protobuf message
+func EventToPB(e *handler.Event) *pb.Event {
+	return &pb.Event{
+		Namespace: e.Namespace,
+		Kind:      e.Kind,
+		Component: e.Component,
+		Host:      e.Host,
+		Reason:    e.Reason,
+		Status:    e.Status,
+		Name:      e.Name,
+		Message:   e.Message(),
+		Time:      timestamppb.New(e.Time),
+	}
+}
@@ -0,0 +1,83 @@
+package process
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8sync/internal/gitops"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/pkg/logger"
+)
+
+// ExportGit writes the source objects into the git working tree,
+// removes manifests of objects gone from source, then commits the changes
+func ExportGit(ctx context.Context, srcK8 *k8client.K8s, repo *gitops.Repo, objs []string) error {
+	ns := srcK8.GetNamespace()
+	for _, kind := range objs {
+		logger.Infof("export %s to git %s", kind, repo.Path())
+		keep := make(map[string]bool)
+		err := eachObject(ctx, srcK8, kind, func(item runtime.Object) error {
+			name := item.(metav1.Object).GetName()
+			keep[name] = true
+			if err := repo.Write(ns, kind, item); err != nil {
+				return fmt.Errorf("write %s %s failed: %w", kind, name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		names, err := repo.Names(ns, kind)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if keep[name] {
+				continue
+			}
+			logger.Infof("  delete %s: %s", kind, name)
+			if err = repo.Remove(ns, kind, name); err != nil {
+				return err
+			}
+		}
+	}
+	return repo.Commit()
+}
+
+// eachObject calls fn with each syncable object of kind page by page
+func eachObject(ctx context.Context, k8 *k8client.K8s, kind string, fn func(runtime.Object) error) error {
+	switch kind {
+	case "deployment":
+		return pagedDeployments(k8)(ctx, func(d *appsv1.Deployment) error { return fn(d) })
+	case "service":
+		return pagedServices(k8)(ctx, func(s *corev1.Service) error { return fn(s) })
+	case "secret":
+		return pagedSecrets(k8)(ctx, func(s *corev1.Secret) error {
+			if !secretSyncable(s) {
+				return nil
+			}
+			return fn(s)
+		})
+	case "configmap":
+		return pagedConfigMaps(k8)(ctx, func(c *corev1.ConfigMap) error {
+			if c.Name == rootCAConfigMap {
+				return nil
+			}
+			return fn(c)
+		})
+	case "serviceaccount":
+		return pagedServiceAccounts(k8)(ctx, func(a *corev1.ServiceAccount) error {
+			if a.Name == defaultServiceAccount {
+				return nil
+			}
+			return fn(a)
+		})
+	case "persistentvolumeclaim":
+		return pagedPersistentVolumeClaims(k8)(ctx, func(c *corev1.PersistentVolumeClaim) error { return fn(c) })
+	}
+	return fmt.Errorf("unsupported object kind: %s", kind)
+}
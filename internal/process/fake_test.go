@@ -0,0 +1,208 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	metafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/pkg/logger"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+// fakeKinds are the kinds the fake metadata clients list
+var fakeKinds = map[schema.GroupVersionResource]string{
+	deploymentResource: "Deployment",
+	serviceResource:    "Service",
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	config.SetDefaults(viper.GetViper())
+	if err := config.Refresh(); err != nil {
+		panic(err)
+	}
+	logger.Initialize(config.Current().Log)
+	os.Exit(m.Run())
+}
+
+// fakeK8s returns a K8s of namespace ns on a fake clientset holding objs, its metadata client lists
+// the objects of the clientset so both always agree
+func fakeK8s(ns string, objs ...runtime.Object) (*k8client.K8s, *fake.Clientset) {
+	cs := fake.NewSimpleClientset(objs...)
+	scheme := metafake.NewTestScheme()
+	_ = metav1.AddMetaToScheme(scheme)
+	md := metafake.NewSimpleMetadataClient(scheme)
+	md.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gvr := action.GetResource()
+		kind, ok := fakeKinds[gvr]
+		if !ok {
+			return false, nil, nil
+		}
+		objs, err := cs.Tracker().List(gvr, gvr.GroupVersion().WithKind(kind), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		items, err := meta.ExtractList(objs)
+		if err != nil {
+			return true, nil, err
+		}
+		list := &metav1.List{}
+		for _, item := range items {
+			m, err := meta.Accessor(item)
+			if err != nil {
+				return true, nil, err
+			}
+			list.Items = append(list.Items, runtime.RawExtension{Object: &metav1.PartialObjectMetadata{
+				TypeMeta: metav1.TypeMeta{APIVersion: gvr.GroupVersion().String(), Kind: kind},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:          m.GetName(),
+					Namespace:     m.GetNamespace(),
+					Labels:        m.GetLabels(),
+					Annotations:   m.GetAnnotations(),
+					ManagedFields: m.GetManagedFields(),
+				},
+			}})
+		}
+		return true, list, nil
+	})
+	return k8client.NewForClients(cs, nil, md, nil, ns), cs
+}
+
+// editedBy records a change of a destination object by manager at the fixed time of the tests
+func editedBy(obj metav1.Object, manager string) {
+	at := metav1.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	obj.SetManagedFields(append(obj.GetManagedFields(), metav1.ManagedFieldsEntry{
+		Manager:   manager,
+		Operation: metav1.ManagedFieldsOperationUpdate,
+		Time:      &at,
+	}))
+}
+
+// actions returns the actions of cs with one of verbs
+func actions(cs *fake.Clientset, verbs ...string) []k8stesting.Action {
+	var found []k8stesting.Action
+	for _, a := range cs.Actions() {
+		for _, v := range verbs {
+			if a.GetVerb() == v {
+				found = append(found, a)
+			}
+		}
+	}
+	return found
+}
+
+// checkGolden compares the objects of list, without their server set fields, with testdata/name.golden,
+// -update writes the file instead
+func checkGolden(t *testing.T, name string, list runtime.Object) {
+	t.Helper()
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].(metav1.Object).GetName() < items[j].(metav1.Object).GetName()
+	})
+	var got bytes.Buffer
+	for i, item := range items {
+		obj := item.DeepCopyObject().(metav1.Object)
+		obj.SetUID("")
+		obj.SetResourceVersion("")
+		obj.SetCreationTimestamp(metav1.Time{})
+		obj.SetManagedFields(nil)
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			got.WriteString("---\n")
+		}
+		got.Write(data)
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err = os.WriteFile(path, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s, run the tests with -update to create it", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("destination objects differ from %s:\n%s\nwant:\n%s", path, got.Bytes(), want)
+	}
+}
+
+// syncCase is a sync of source objects into a destination namespace holding dst
+type syncCase struct {
+	name string
+	dst  []runtime.Object
+	opts *Options
+	// edit changes the source or the destination after a first sync, the case checks the second sync.
+	// nil syncs once
+	edit func(ctx context.Context, t *testing.T, src, dst *fake.Clientset)
+	// writes and gets are the numbers of objects the checked sync writes and fetches one by one
+	writes int
+	gets   int
+	// conflicts is the number of objects changed on both sides the source won
+	conflicts int
+}
+
+// runSyncCase syncs src to the destination of c with sync and checks the writes and the destination objects
+// listed by list against the golden file prefix_name
+func runSyncCase(t *testing.T, prefix string, c syncCase, src []runtime.Object,
+	sync func(ctx context.Context, srcK8, dstK8 *k8client.K8s, opts *Options, rec Recorder) error,
+	list func(ctx context.Context, cs *fake.Clientset) (runtime.Object, error)) {
+	ctx := context.Background()
+	srcK8, srcCs := fakeK8s("src", src...)
+	dstK8, cs := fakeK8s("dst", c.dst...)
+	if c.edit != nil {
+		if err := sync(ctx, srcK8, dstK8, c.opts, NewSummary()); err != nil {
+			t.Fatal(err)
+		}
+		c.edit(ctx, t, srcCs, cs)
+		cs.ClearActions()
+	}
+
+	summary := NewSummary()
+	if err := sync(ctx, srcK8, dstK8, c.opts, summary); err != nil {
+		t.Fatal(err)
+	}
+	if failures := summary.Failures(); len(failures) > 0 {
+		t.Fatalf("failures: %v", failures)
+	}
+	if got := actions(cs, "create", "update", "patch", "delete"); len(got) != c.writes {
+		t.Errorf("%d writes, want %d: %v", len(got), c.writes, got)
+	}
+	if got := actions(cs, "get"); len(got) != c.gets {
+		t.Errorf("%d gets, want %d: %v", len(got), c.gets, got)
+	}
+	if got := summary.Conflicts(); len(got) != c.conflicts {
+		t.Errorf("%d conflicts, want %d: %v", len(got), c.conflicts, got)
+	}
+	dst, err := list(ctx, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, prefix+"_"+c.name, dst)
+}
@@ -1,20 +1,150 @@
 package process
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"k8sync/gen/proto/k8sync/v1"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/pkg/logger"
 )
 
+// clusterCheckTimeout bounds the api server version request of each cluster
+const clusterCheckTimeout = 5 * time.Second
+
+// Watcher is the k8s controller state reported by health check
+type Watcher interface {
+	HasSynced() bool
+	QueueLen() int
+	LastSyncTime() time.Time
+}
+
 // Health implements the protobuf interface
 type Health struct {
 	pb.UnimplementedHealthServiceServer
-	mu *sync.RWMutex
+	mu       *sync.RWMutex
+	clusters map[string]*k8client.K8s
+	watcher  Watcher
 }
 
 // NewHealth initializes a new Health struct.
 func NewHealth() *Health {
 	return &Health{
-		mu: &sync.RWMutex{},
+		mu:       &sync.RWMutex{},
+		clusters: make(map[string]*k8client.K8s),
+	}
+}
+
+// AddCluster adds the api server of cluster name into health check
+func (h *Health) AddCluster(name string, k8s *k8client.K8s) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clusters[name] = k8s
+}
+
+// SetWatcher sets the controller whose sync state is reported
+func (h *Health) SetWatcher(w Watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watcher = w
+}
+
+// IsHealth reports api server reachability, informer sync state,
+// queue depth and last successful sync time
+func (h *Health) IsHealth(ctx context.Context, req *pb.IsHealthRequest) (*pb.IsHealthResponse, error) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.clusters))
+	for name := range h.clusters {
+		names = append(names, name)
+	}
+	clusters := make(map[string]*k8client.K8s, len(h.clusters))
+	for name, k8s := range h.clusters {
+		clusters[name] = k8s
+	}
+	watcher := h.watcher
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	resp := &pb.IsHealthResponse{Healthy: true}
+	for _, name := range names {
+		ch := checkCluster(ctx, name, clusters[name])
+		resp.Healthy = resp.Healthy && ch.Reachable
+		resp.Clusters = append(resp.Clusters, ch)
+	}
+	if watcher != nil {
+		resp.Synced = watcher.HasSynced()
+		resp.QueueDepth = int32(watcher.QueueLen())
+		if t := watcher.LastSyncTime(); !t.IsZero() {
+			resp.LastSyncTime = timestamppb.New(t)
+		}
+	}
+	resp.Healthy = resp.Healthy && resp.Synced
+	return resp, nil
+}
+
+// Ready reports whether the daemon is ready to serve
+func (h *Health) Ready(ctx context.Context) (*pb.IsHealthResponse, bool) {
+	resp, err := h.IsHealth(ctx, &pb.IsHealthRequest{})
+	if err != nil {
+		return resp, false
+	}
+	return resp, resp.Healthy
+}
+
+// Watch refreshes the serving status of the standard grpc health service
+// every interval, until ctx is done
+func (h *Health) Watch(ctx context.Context, hs *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if _, ready := h.Ready(ctx); ready {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(pb.HealthService_ServiceDesc.ServiceName, status)
+		select {
+		case <-ctx.Done():
+			hs.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkCluster(ctx context.Context, name string, k8s *k8client.K8s) *pb.ClusterHealth {
+	type result struct {
+		version string
+		err     error
+	}
+	ch := &pb.ClusterHealth{Name: name}
+	done := make(chan result, 1)
+	go func() {
+		version, err := k8s.GetVersion()
+		done <- result{version, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(clusterCheckTimeout):
+		r.err = errors.New("request api server version timeout")
+	case <-ctx.Done():
+		r.err = ctx.Err()
+	}
+	if r.err != nil {
+		logger.Warnf("health check %s cluster failed: %s", name, r.err)
+		ch.Error = r.err.Error()
+		return ch
 	}
+	ch.Reachable = true
+	ch.Version = r.version
+	return ch
 }
@@ -0,0 +1,340 @@
+package process
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// IgnoreRule leaves fields of destination objects to other controllers, e.g. the replicas of a deployment
+// scaled by an HPA or the containers injected by a mesh. ignored fields are neither compared nor overwritten
+type IgnoreRule struct {
+	Kind         string   // object kind, empty for all kinds
+	Name         string   // object name pattern, empty for all names
+	Selector     string   // label selector of the source object, empty for all objects
+	JSONPointers []string // RFC 6901 pointers like /spec/replicas
+	// JSONPaths are kubectl JSONPath expressions like {.spec.template.spec.containers[?(@.name=="istio-proxy")]},
+	// they select objects, lists or the fields of objects
+	JSONPaths []string
+}
+
+// Validate checks the pointers, paths, name pattern and selector of r
+func (r IgnoreRule) Validate() error {
+	if len(r.JSONPointers) == 0 && len(r.JSONPaths) == 0 {
+		return fmt.Errorf("no json pointer or json path")
+	}
+	if _, err := path.Match(r.Name, ""); err != nil {
+		return fmt.Errorf("invalid name pattern %q: %w", r.Name, err)
+	}
+	if _, err := labels.Parse(r.Selector); err != nil {
+		return fmt.Errorf("invalid selector %q: %w", r.Selector, err)
+	}
+	for _, p := range r.JSONPointers {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("json pointer %q does not start with /", p)
+		}
+	}
+	for _, p := range r.JSONPaths {
+		if _, err := jsonpath.Parse("ignore", jsonPathTemplate(p)); err != nil {
+			return fmt.Errorf("invalid json path %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// ignoreRules returns the rules applying to the source object obj of kind
+func (o *Options) ignoreRules(kind string, obj metav1.Object) []IgnoreRule {
+	if o == nil {
+		return nil
+	}
+	var rules []IgnoreRule
+	for _, r := range o.IgnoreDifferences {
+		if r.Kind != "" && r.Kind != kind || r.Name != "" && !matchAny([]string{r.Name}, obj.GetName()) {
+			continue
+		}
+		if r.Selector != "" {
+			selector, err := labels.Parse(r.Selector)
+			if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+				continue
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// keepIgnored replaces the fields of the unstructured object u ignored by rules with those of
+// the destination object du, fields missing in du are dropped
+func keepIgnored(u, du map[string]interface{}, rules []IgnoreRule) error {
+	if err := stripIgnored(u, rules); err != nil {
+		return err
+	}
+	pointers, err := ignoredPointers(du, rules)
+	if err != nil {
+		return err
+	}
+	for _, p := range pointers {
+		if v, ok := getPointer(du, p); ok {
+			setPointer(u, p, runtime.DeepCopyJSONValue(v))
+		}
+	}
+	return nil
+}
+
+// stripIgnored removes the fields ignored by rules from the unstructured object u
+func stripIgnored(u map[string]interface{}, rules []IgnoreRule) error {
+	pointers, err := ignoredPointers(u, rules)
+	if err != nil {
+		return err
+	}
+	for i := len(pointers) - 1; i >= 0; i-- {
+		removePointer(u, pointers[i])
+	}
+	return nil
+}
+
+// ignoredPointers resolves the fields of u ignored by rules to pointers, in document order
+// so that list items are removed backwards and inserted forwards
+func ignoredPointers(u map[string]interface{}, rules []IgnoreRule) ([][]string, error) {
+	seen := make(map[string]bool)
+	var pointers [][]string
+	add := func(p []string) {
+		if key := strings.Join(p, "/"); !seen[key] {
+			seen[key] = true
+			pointers = append(pointers, p)
+		}
+	}
+	for _, r := range rules {
+		for _, p := range r.JSONPointers {
+			if tokens := parsePointer(p); tokens != nil {
+				if _, ok := getPointer(u, tokens); ok {
+					add(tokens)
+				}
+			}
+		}
+		for _, p := range r.JSONPaths {
+			found, err := pathPointers(u, p)
+			if err != nil {
+				return nil, err
+			}
+			for _, tokens := range found {
+				add(tokens)
+			}
+		}
+	}
+	sort.Slice(pointers, func(i, j int) bool { return pointerLess(pointers[i], pointers[j]) })
+	return pointers, nil
+}
+
+// trailingField splits the last field off a JSONPath template
+var trailingField = regexp.MustCompile(`^\{(.*)\.([A-Za-z0-9_-]+)\}$`)
+
+// pathPointers resolves the JSONPath expression expr in u. jsonpath returns values, not locations,
+// so objects and lists are found back by identity and a trailing field is resolved on its parents
+func pathPointers(u map[string]interface{}, expr string) ([][]string, error) {
+	expr = jsonPathTemplate(expr)
+	field := ""
+	if m := trailingField.FindStringSubmatch(expr); m != nil {
+		expr, field = "{"+m[1]+"}", m[2]
+	}
+	var results []reflect.Value
+	if expr == "{}" {
+		results = []reflect.Value{reflect.ValueOf(u)}
+	} else {
+		j := jsonpath.New("ignore").AllowMissingKeys(true)
+		if err := j.Parse(expr); err != nil {
+			return nil, fmt.Errorf("invalid json path %q: %w", expr, err)
+		}
+		found, err := j.FindResults(u)
+		if err != nil {
+			return nil, fmt.Errorf("evaluate json path %q failed: %w", expr, err)
+		}
+		for _, r := range found {
+			results = append(results, r...)
+		}
+	}
+
+	locations := make(map[uintptr][]string)
+	var index func(v interface{}, at []string)
+	index = func(v interface{}, at []string) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			locations[reflect.ValueOf(v).Pointer()] = at
+			for k, e := range v {
+				index(e, append(at[:len(at):len(at)], k))
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				locations[reflect.ValueOf(v).Pointer()] = at
+			}
+			for i, e := range v {
+				index(e, append(at[:len(at):len(at)], strconv.Itoa(i)))
+			}
+		}
+	}
+	index(u, []string{})
+
+	var pointers [][]string
+	for _, r := range results {
+		for r.Kind() == reflect.Interface {
+			r = r.Elem()
+		}
+		if r.Kind() != reflect.Map && r.Kind() != reflect.Slice {
+			continue
+		}
+		at, ok := locations[r.Pointer()]
+		if !ok {
+			continue
+		}
+		if field == "" {
+			pointers = append(pointers, at)
+		} else if m, ok := r.Interface().(map[string]interface{}); ok {
+			if _, ok := m[field]; ok {
+				pointers = append(pointers, append(at[:len(at):len(at)], field))
+			}
+		}
+	}
+	return pointers, nil
+}
+
+// jsonPathTemplate wraps a bare JSONPath expression like .spec.replicas in braces
+func jsonPathTemplate(expr string) string {
+	if strings.HasPrefix(expr, "{") {
+		return expr
+	}
+	return "{" + expr + "}"
+}
+
+// parsePointer splits an RFC 6901 pointer into its unescaped tokens
+func parsePointer(p string) []string {
+	if !strings.HasPrefix(p, "/") {
+		return nil
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// pointerLess orders pointers in document order, list indexes numerically
+func pointerLess(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errX := strconv.Atoi(a[i])
+		y, errY := strconv.Atoi(b[i])
+		if errX == nil && errY == nil {
+			return x < y
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
+
+func getPointer(u interface{}, tokens []string) (interface{}, bool) {
+	v := u
+	for _, t := range tokens {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			e, ok := c[t]
+			if !ok {
+				return nil, false
+			}
+			v = e
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			v = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setPointer sets the value at tokens, creating missing objects. the last token of a list is inserted
+// at its index, or appended when the list is shorter
+func setPointer(u map[string]interface{}, tokens []string, value interface{}) {
+	if len(tokens) == 0 {
+		return
+	}
+	var set func(parent interface{}, tokens []string) interface{}
+	set = func(parent interface{}, tokens []string) interface{} {
+		t := tokens[0]
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if len(tokens) == 1 {
+				c[t] = value
+			} else {
+				c[t] = set(c[t], tokens[1:])
+			}
+			return c
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 {
+				return c
+			}
+			if len(tokens) == 1 {
+				if i > len(c) {
+					i = len(c)
+				}
+				return append(c[:i], append([]interface{}{value}, c[i:]...)...)
+			}
+			if i < len(c) {
+				c[i] = set(c[i], tokens[1:])
+			}
+			return c
+		case nil:
+			if _, err := strconv.Atoi(t); err == nil {
+				return set([]interface{}{}, tokens)
+			}
+			return set(map[string]interface{}{}, tokens)
+		}
+		return parent
+	}
+	set(u, tokens)
+}
+
+// removePointer removes the value at tokens, a list item shifts the items after it
+func removePointer(u map[string]interface{}, tokens []string) {
+	var remove func(parent interface{}, tokens []string) interface{}
+	remove = func(parent interface{}, tokens []string) interface{} {
+		t := tokens[0]
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if len(tokens) == 1 {
+				delete(c, t)
+			} else if e, ok := c[t]; ok {
+				c[t] = remove(e, tokens[1:])
+			}
+			return c
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(c) {
+				return c
+			}
+			if len(tokens) == 1 {
+				return append(c[:i], c[i+1:]...)
+			}
+			c[i] = remove(c[i], tokens[1:])
+			return c
+		}
+		return parent
+	}
+	if len(tokens) > 0 {
+		remove(u, tokens)
+	}
+}
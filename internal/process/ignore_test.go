@@ -0,0 +1,139 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ignoreObject returns a new unstructured deployment with a mesh sidecar
+func ignoreObject() map[string]interface{} {
+	type m = map[string]interface{}
+	type l = []interface{}
+	return m{
+		"metadata": m{"name": "web", "labels": m{"app": "web"}},
+		"spec": m{
+			"replicas": int64(2),
+			"template": m{"spec": m{"containers": l{
+				m{"name": "web", "image": "nginx"},
+				m{"name": "istio-proxy", "image": "proxy", "resources": m{"limits": m{"cpu": "1"}}},
+			}}},
+		},
+	}
+}
+
+func TestPathPointers(t *testing.T) {
+	cases := []struct {
+		name string
+		expr string
+		want [][]string
+	}{
+		{name: "field", expr: ".spec.replicas", want: [][]string{{"spec", "replicas"}}},
+		{name: "braced field", expr: "{.spec.replicas}", want: [][]string{{"spec", "replicas"}}},
+		{name: "object", expr: "{.metadata.labels}", want: [][]string{{"metadata", "labels"}}},
+		{name: "missing field", expr: ".spec.paused"},
+		{
+			name: "filtered item",
+			expr: `{.spec.template.spec.containers[?(@.name=="istio-proxy")]}`,
+			want: [][]string{{"spec", "template", "spec", "containers", "1"}},
+		},
+		{
+			name: "field of filtered item",
+			expr: `{.spec.template.spec.containers[?(@.name=="istio-proxy")].resources}`,
+			want: [][]string{{"spec", "template", "spec", "containers", "1", "resources"}},
+		},
+		{
+			name: "field of every item",
+			expr: "{.spec.template.spec.containers[*].image}",
+			want: [][]string{
+				{"spec", "template", "spec", "containers", "0", "image"},
+				{"spec", "template", "spec", "containers", "1", "image"},
+			},
+		},
+		{name: "root", expr: "{}", want: [][]string{{}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := pathPointers(ignoreObject(), c.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("pathPointers(%s) = %v, want %v", c.expr, got, c.want)
+			}
+		})
+	}
+
+	if _, err := pathPointers(ignoreObject(), "{.spec[}"); err == nil {
+		t.Error("an invalid json path is not an error")
+	}
+}
+
+func TestSetPointer(t *testing.T) {
+	type m = map[string]interface{}
+	type l = []interface{}
+	cases := []struct {
+		name   string
+		u      m
+		tokens []string
+		value  interface{}
+		want   m
+	}{
+		{name: "replace", u: m{"a": "1"}, tokens: []string{"a"}, value: "2", want: m{"a": "2"}},
+		{name: "add", u: m{"a": "1"}, tokens: []string{"b"}, value: "2", want: m{"a": "1", "b": "2"}},
+		{name: "create objects", u: m{}, tokens: []string{"a", "b"}, value: "1", want: m{"a": m{"b": "1"}}},
+		{name: "create list", u: m{}, tokens: []string{"a", "0"}, value: "x", want: m{"a": l{"x"}}},
+		{name: "insert item", u: m{"a": l{"x", "z"}}, tokens: []string{"a", "1"}, value: "y", want: m{"a": l{"x", "y", "z"}}},
+		{name: "append item", u: m{"a": l{"x"}}, tokens: []string{"a", "5"}, value: "y", want: m{"a": l{"x", "y"}}},
+		{
+			name:   "field of item",
+			u:      m{"a": l{m{"name": "x"}}},
+			tokens: []string{"a", "0", "image"},
+			value:  "i",
+			want:   m{"a": l{m{"name": "x", "image": "i"}}},
+		},
+		{name: "missing item", u: m{"a": l{}}, tokens: []string{"a", "3", "image"}, value: "i", want: m{"a": l{}}},
+		{name: "scalar parent", u: m{"a": "1"}, tokens: []string{"a", "b"}, value: "2", want: m{"a": "1"}},
+		{name: "no tokens", u: m{"a": "1"}, value: "2", want: m{"a": "1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setPointer(c.u, c.tokens, c.value)
+			if !reflect.DeepEqual(c.u, c.want) {
+				t.Errorf("setPointer(%v) = %v, want %v", c.tokens, c.u, c.want)
+			}
+		})
+	}
+}
+
+func TestRemovePointer(t *testing.T) {
+	type m = map[string]interface{}
+	type l = []interface{}
+	cases := []struct {
+		name   string
+		u      m
+		tokens []string
+		want   m
+	}{
+		{name: "field", u: m{"a": "1", "b": "2"}, tokens: []string{"a"}, want: m{"b": "2"}},
+		{name: "nested field", u: m{"a": m{"b": "1", "c": "2"}}, tokens: []string{"a", "b"}, want: m{"a": m{"c": "2"}}},
+		{name: "item", u: m{"a": l{"x", "y", "z"}}, tokens: []string{"a", "1"}, want: m{"a": l{"x", "z"}}},
+		{
+			name:   "field of item",
+			u:      m{"a": l{m{"name": "x", "image": "i"}}},
+			tokens: []string{"a", "0", "image"},
+			want:   m{"a": l{m{"name": "x"}}},
+		},
+		{name: "missing field", u: m{"a": "1"}, tokens: []string{"b", "c"}, want: m{"a": "1"}},
+		{name: "missing item", u: m{"a": l{"x"}}, tokens: []string{"a", "3"}, want: m{"a": l{"x"}}},
+		{name: "index not a number", u: m{"a": l{"x"}}, tokens: []string{"a", "x"}, want: m{"a": l{"x"}}},
+		{name: "no tokens", u: m{"a": "1"}, want: m{"a": "1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			removePointer(c.u, c.tokens)
+			if !reflect.DeepEqual(c.u, c.want) {
+				t.Errorf("removePointer(%v) = %v, want %v", c.tokens, c.u, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package process
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+
+	"k8sync/pkg/logger"
+)
+
+// lastSyncedAnnotation holds the compressed source object a destination object was last synced from,
+// the base of three-way merges
+const lastSyncedAnnotation = "k8sync.io/last-synced"
+
+// maxSnapshotSize bounds the last synced annotation, annotations of an object are limited to 256KiB
+const maxSnapshotSize = 128 << 10
+
+// ConflictRecorder is a Recorder which also reports the fields changed both in the source
+// and in the destination since the last sync, the source value is applied
+type ConflictRecorder interface {
+	RecordConflict(kind, name string, fields []string)
+}
+
+// threeWay reports whether objects of kind are merged with their destination object,
+// secrets are not as their values would be copied to the last synced annotation
+func (o *Options) threeWay(kind string) bool {
+	return o != nil && o.ThreeWayMerge && kind != "secret"
+}
+
+// stampSnapshot sets the last synced annotation of the filtered and transformed source object obj,
+// a snapshot too large is not kept and the next update overwrites the destination object
+func stampSnapshot(obj metav1.Object) error {
+	annotations := obj.GetAnnotations()
+	delete(annotations, lastSyncedAnnotation)
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return fmt.Errorf("snapshot %s failed: %w", obj.GetName(), err)
+	}
+	sanitizeSnapshot(u)
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("snapshot %s failed: %w", obj.GetName(), err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(data); err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("snapshot %s failed: %w", obj.GetName(), err)
+	}
+	snapshot := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(snapshot) > maxSnapshotSize {
+		logger.Warnf("snapshot of %s is %d bytes, larger than %d, it will be overwritten on update", obj.GetName(), len(snapshot), maxSnapshotSize)
+		return nil
+	}
+	obj.SetAnnotations(merge(annotations, map[string]string{lastSyncedAnnotation: snapshot}))
+	return nil
+}
+
+// lastSynced decodes the last synced annotation of a destination object, nil when it has none
+func lastSynced(obj metav1.Object) (map[string]interface{}, error) {
+	snapshot, ok := obj.GetAnnotations()[lastSyncedAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s of %s failed: %w", lastSyncedAnnotation, obj.GetName(), err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s of %s failed: %w", lastSyncedAnnotation, obj.GetName(), err)
+	}
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s of %s failed: %w", lastSyncedAnnotation, obj.GetName(), err)
+	}
+	var base map[string]interface{}
+	if err = utiljson.Unmarshal(data, &base); err != nil { // numbers as int64 like the unstructured converter
+		return nil, fmt.Errorf("decode %s of %s failed: %w", lastSyncedAnnotation, obj.GetName(), err)
+	}
+	return base, nil
+}
+
+// sanitizeSnapshot drops the sync annotations and the status of the unstructured object u
+func sanitizeSnapshot(u map[string]interface{}) {
+	delete(u, "status")
+	if meta, ok := u["metadata"].(map[string]interface{}); ok {
+		if annotations, ok := meta["annotations"].(map[string]interface{}); ok {
+			delete(annotations, sourceHashAnnotation)
+			delete(annotations, lastSyncedAnnotation)
+			if len(annotations) == 0 {
+				delete(meta, "annotations")
+			}
+		}
+	}
+}
+
+// mergeDestination fetches the destination object of obj and makes obj the object to write:
+// with threeWay the destination changes since the last sync are kept unless they conflict with the source,
+// then the fields ignored by rules are kept from the destination. it returns the conflicting fields
+func mergeDestination[T any, PT object[T]](ctx context.Context, client objectClient[PT], obj PT, rules []IgnoreRule, threeWay bool) ([]string, error) {
+	dst, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]string)
+	for _, k := range []string{sourceHashAnnotation, lastSyncedAnnotation} {
+		if v, ok := obj.GetAnnotations()[k]; ok {
+			keep[k] = v
+		}
+	}
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	du, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dst)
+	if err != nil {
+		return nil, err
+	}
+	sanitizeSnapshot(u)
+	sanitizeSnapshot(du)
+
+	var conflicts []string
+	if threeWay {
+		base, err := lastSynced(dst)
+		if err != nil {
+			logger.Warnf("%s, the destination is overwritten", err)
+		}
+		if base != nil {
+			merged, _ := mergeValue("", base, true, u, true, du, true, &conflicts)
+			u = merged.(map[string]interface{})
+		}
+	}
+	if err = keepIgnored(u, du, rules); err != nil {
+		return nil, err
+	}
+	var zero T
+	*obj = zero
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(u, obj); err != nil {
+		return nil, err
+	}
+	obj.SetAnnotations(merge(obj.GetAnnotations(), keep))
+	return conflicts, nil
+}
+
+// mergeValue merges the source value s and the destination value d at path with their last synced value b,
+// ok flags tell whether a value is set. a value changed on one side only takes that change, objects and
+// lists of named objects are merged by key, any other value changed on both sides is a conflict the source wins
+func mergeValue(path string, b interface{}, bok bool, s interface{}, sok bool, d interface{}, dok bool, conflicts *[]string) (interface{}, bool) {
+	same := func(x interface{}, xok bool, y interface{}, yok bool) bool {
+		return xok == yok && (!xok || equality.Semantic.DeepEqual(x, y))
+	}
+	switch {
+	case same(s, sok, b, bok):
+		return d, dok
+	case same(d, dok, b, bok), same(s, sok, d, dok):
+		return s, sok
+	}
+
+	if sm, ok := s.(map[string]interface{}); ok {
+		if dm, ok := d.(map[string]interface{}); ok {
+			bm, _ := b.(map[string]interface{})
+			merged := make(map[string]interface{})
+			for _, k := range unionKeys(bm, sm, dm) {
+				bv, bok := bm[k]
+				sv, sok := sm[k]
+				dv, dok := dm[k]
+				if v, ok := mergeValue(path+"."+k, bv, bok, sv, sok, dv, dok, conflicts); ok {
+					merged[k] = v
+				}
+			}
+			return merged, true
+		}
+	}
+	if sl, ok := namedItems(s); ok {
+		if dl, ok := namedItems(d); ok {
+			bl, _ := namedItems(b)
+			var merged []interface{}
+			for _, name := range itemNames(s, d) {
+				bv, bok := bl[name]
+				sv, sok := sl[name]
+				dv, dok := dl[name]
+				if v, ok := mergeValue(path+"["+name+"]", bv, bok, sv, sok, dv, dok, conflicts); ok {
+					merged = append(merged, v)
+				}
+			}
+			return merged, true
+		}
+	}
+	*conflicts = append(*conflicts, strings.TrimPrefix(path, "."))
+	return s, sok
+}
+
+func unionKeys(maps ...map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// namedItems indexes a list of objects by their name, like containers or env, false for any other value
+func namedItems(v interface{}) (map[string]interface{}, bool) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	items := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok || items[name] != nil {
+			return nil, false
+		}
+		items[name] = item
+	}
+	return items, true
+}
+
+// itemNames returns the names of the source items in order, then those only in the destination
+func itemNames(s, d interface{}) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, list := range []interface{}{s, d} {
+		for _, item := range list.([]interface{}) {
+			name := item.(map[string]interface{})["name"].(string)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,142 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeValue(t *testing.T) {
+	type m = map[string]interface{}
+	type l = []interface{}
+	cases := []struct {
+		name      string
+		b, s, d   interface{}
+		want      interface{}
+		wantOK    bool
+		conflicts []string
+	}{
+		{name: "unchanged", b: "a", s: "a", d: "a", want: "a", wantOK: true},
+		{name: "source change", b: "a", s: "b", d: "a", want: "b", wantOK: true},
+		{name: "destination change", b: "a", s: "a", d: "c", want: "c", wantOK: true},
+		{name: "same change", b: "a", s: "b", d: "b", want: "b", wantOK: true},
+		{name: "conflict", b: "a", s: "b", d: "c", want: "b", wantOK: true, conflicts: []string{"x"}},
+		{
+			name:   "destination only field",
+			b:      m{"a": "1"},
+			s:      m{"a": "2"},
+			d:      m{"a": "1", "b": "3"},
+			want:   m{"a": "2", "b": "3"},
+			wantOK: true,
+		},
+		{
+			name:   "field removed in source",
+			b:      m{"a": "1", "b": "2"},
+			s:      m{"a": "1"},
+			d:      m{"a": "1", "b": "2", "c": "3"},
+			want:   m{"a": "1", "c": "3"},
+			wantOK: true,
+		},
+		{
+			name:   "field removed in destination",
+			b:      m{"a": "1", "b": "2"},
+			s:      m{"a": "9", "b": "2"},
+			d:      m{"a": "1"},
+			want:   m{"a": "9"},
+			wantOK: true,
+		},
+		{
+			name:      "nested conflict",
+			b:         m{"spec": m{"replicas": int64(2)}},
+			s:         m{"spec": m{"replicas": int64(3)}},
+			d:         m{"spec": m{"replicas": int64(5)}},
+			want:      m{"spec": m{"replicas": int64(3)}},
+			wantOK:    true,
+			conflicts: []string{"x.spec.replicas"},
+		},
+		{
+			name:   "named items",
+			b:      l{m{"name": "web", "image": "a"}},
+			s:      l{m{"name": "web", "image": "b"}},
+			d:      l{m{"name": "web", "image": "a", "tty": true}, m{"name": "proxy"}},
+			want:   l{m{"name": "web", "image": "b", "tty": true}, m{"name": "proxy"}},
+			wantOK: true,
+		},
+		{
+			name:   "named item removed in source",
+			b:      l{m{"name": "web"}, m{"name": "debug"}},
+			s:      l{m{"name": "web", "image": "b"}},
+			d:      l{m{"name": "web"}, m{"name": "debug"}},
+			want:   l{m{"name": "web", "image": "b"}},
+			wantOK: true,
+		},
+		{
+			name:      "unnamed items conflict",
+			b:         l{"a"},
+			s:         l{"b"},
+			d:         l{"c"},
+			want:      l{"b"},
+			wantOK:    true,
+			conflicts: []string{"x"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var conflicts []string
+			got, ok := mergeValue(".x", c.b, c.b != nil, c.s, c.s != nil, c.d, c.d != nil, &conflicts)
+			if !reflect.DeepEqual(got, c.want) || ok != c.wantOK {
+				t.Errorf("mergeValue = %v, %v, want %v, %v", got, ok, c.want, c.wantOK)
+			}
+			if !reflect.DeepEqual(conflicts, c.conflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, c.conflicts)
+			}
+		})
+	}
+}
+
+func TestMergeValueUnset(t *testing.T) {
+	var conflicts []string
+	if _, ok := mergeValue(".x", "a", true, nil, false, "a", true, &conflicts); ok {
+		t.Error("a field removed in the source is kept")
+	}
+	if got, ok := mergeValue(".x", nil, false, nil, false, "d", true, &conflicts); !ok || got != "d" {
+		t.Errorf("a field added in the destination is %v, %v", got, ok)
+	}
+	if len(conflicts) > 0 {
+		t.Errorf("conflicts = %v", conflicts)
+	}
+}
+
+func TestNamedItems(t *testing.T) {
+	type m = map[string]interface{}
+	cases := []struct {
+		name string
+		v    interface{}
+		want []string
+		ok   bool
+	}{
+		{name: "named", v: []interface{}{m{"name": "a"}, m{"name": "b", "x": "1"}}, want: []string{"a", "b"}, ok: true},
+		{name: "empty", v: []interface{}{}},
+		{name: "not a list", v: m{"name": "a"}},
+		{name: "scalars", v: []interface{}{"a", "b"}},
+		{name: "unnamed item", v: []interface{}{m{"name": "a"}, m{"port": int64(80)}}},
+		{name: "duplicate names", v: []interface{}{m{"name": "a"}, m{"name": "a"}}},
+		{name: "name not a string", v: []interface{}{m{"name": int64(1)}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			items, ok := namedItems(c.v)
+			if ok != c.ok {
+				t.Fatalf("namedItems ok = %v, want %v", ok, c.ok)
+			}
+			var names []string
+			for _, name := range c.want {
+				if _, found := items[name]; found {
+					names = append(names, name)
+				}
+			}
+			if len(items) != len(c.want) || !reflect.DeepEqual(names, c.want) {
+				t.Errorf("namedItems = %v, want the names %v", items, c.want)
+			}
+		})
+	}
+}
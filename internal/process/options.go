@@ -0,0 +1,148 @@
+package process
+
+import (
+	"path"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8sync/internal/config"
+)
+
+// Options select and change the objects of a sync, a nil Options syncs all objects unchanged
+type Options struct {
+	Include           []string // object name patterns, empty to include all
+	Exclude           []string // object name patterns, applied after Include
+	Transforms        []Transform
+	Dependencies      bool         // also sync the objects the synced deployments reference
+	IgnoreDifferences []IgnoreRule // destination fields owned by other controllers
+	ThreeWayMerge     bool         // keep the destination changes made since the last sync which do not conflict
+	Verify            *Verify      // verify the rollout after the sync, nil to skip
+
+	names     map[string]bool // selected besides Include
+	onlyNames bool            // select names only
+
+	verification *verification // objects to verify, set by SyncNamespace
+}
+
+// Transform changes source objects before they are applied to the destination
+type Transform struct {
+	Kinds       []string          // object kinds, empty for all kinds
+	Labels      map[string]string // labels to set
+	Annotations map[string]string // annotations to set
+	Images      []ImageRewrite    // container image rewrites, deployments only
+	Replicas    *int32            // replicas to set, deployments only
+}
+
+// ImageRewrite replaces the image prefix From with To
+type ImageRewrite struct {
+	From string
+	To   string
+}
+
+// OptionsFromConfig returns the options of src.include, src.exclude, src.dependencies, sync.ignore-differences,
+// sync.three-way-merge and sync.verify
+func OptionsFromConfig() *Options {
+	s := config.Current()
+	opts := &Options{Include: s.Src.Include, Exclude: s.Src.Exclude, Dependencies: s.Src.Dependencies,
+		ThreeWayMerge: s.Sync.ThreeWayMerge}
+	if v := s.Sync.Verify; v.Enabled {
+		opts.Verify = &Verify{Timeout: v.Timeout, Rollback: v.Rollback}
+	}
+	for _, r := range s.Sync.IgnoreDifferences {
+		opts.IgnoreDifferences = append(opts.IgnoreDifferences, IgnoreRule{
+			Kind:         r.Kind,
+			Name:         r.Name,
+			Selector:     r.Selector,
+			JSONPointers: r.JSONPointers,
+			JSONPaths:    r.JSONPaths,
+		})
+	}
+	return opts
+}
+
+// selected reports whether the object name is synced,
+// destination objects not selected are neither updated nor deleted
+func (o *Options) selected(name string) bool {
+	if o == nil {
+		return true
+	}
+	if matchAny(o.Exclude, name) {
+		return false
+	}
+	if o.names[name] {
+		return true
+	}
+	return !o.onlyNames && (len(o.Include) == 0 || matchAny(o.Include, name))
+}
+
+// transform applies the transforms of kind to the object meta of a source object
+func (o *Options) transform(kind string, obj metav1.Object) {
+	if o == nil {
+		return
+	}
+	for _, t := range o.Transforms {
+		if len(t.Kinds) > 0 && !contains(t.Kinds, kind) {
+			continue
+		}
+		obj.SetLabels(merge(obj.GetLabels(), t.Labels))
+		obj.SetAnnotations(merge(obj.GetAnnotations(), t.Annotations))
+	}
+}
+
+// transformDeployment applies the transforms of deployments to d
+func (o *Options) transformDeployment(d *appsv1.Deployment) {
+	if o == nil {
+		return
+	}
+	o.transform("deployment", d)
+	for _, t := range o.Transforms {
+		if len(t.Kinds) > 0 && !contains(t.Kinds, "deployment") {
+			continue
+		}
+		if t.Replicas != nil {
+			replicas := *t.Replicas
+			d.Spec.Replicas = &replicas
+		}
+		spec := &d.Spec.Template.Spec
+		for _, r := range t.Images {
+			for i := range spec.InitContainers {
+				spec.InitContainers[i].Image = rewriteImage(spec.InitContainers[i].Image, r)
+			}
+			for i := range spec.Containers {
+				spec.Containers[i].Image = rewriteImage(spec.Containers[i].Image, r)
+			}
+		}
+	}
+}
+
+func rewriteImage(image string, r ImageRewrite) string {
+	if r.From == "" || !strings.HasPrefix(image, r.From) {
+		return image
+	}
+	return r.To + strings.TrimPrefix(image, r.From)
+}
+
+// matchAny reports whether name matches one of the shell patterns
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func merge(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,228 @@
+package process
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+)
+
+// sourceHashAnnotation holds the hash of the source object a destination object was last synced from
+const sourceHashAnnotation = "k8sync.io/source-hash"
+
+// fieldManager names k8sync in the managed fields of the objects it writes
+const fieldManager = "k8sync"
+
+// notTokenSecrets selects the secrets secretSyncable accepts, the type is not part of the metadata
+const notTokenSecrets = "type!=" + string(corev1.SecretTypeServiceAccountToken)
+
+var (
+	deploymentResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	serviceResource    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	secretResource     = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	configMapResource  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	accountResource    = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	claimResource      = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+)
+
+// source calls fn with each source object until fn fails, only one page of objects is held at a time
+type source[T any] func(ctx context.Context, fn func(*T) error) error
+
+// eachPage lists with sync.page-size objects per request, list returns the continue token of its page
+func eachPage(ctx context.Context, opts metav1.ListOptions, list func(metav1.ListOptions) (string, error)) error {
+	opts.Limit = config.Current().Sync.PageSize
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		next, err := list(opts)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Continue = next
+	}
+}
+
+// paged is the source of the objects listed page by page by list
+func paged[T any](list func(ctx context.Context, opts metav1.ListOptions) ([]T, string, error)) source[T] {
+	return func(ctx context.Context, fn func(*T) error) error {
+		return eachPage(ctx, metav1.ListOptions{}, func(opts metav1.ListOptions) (string, error) {
+			items, next, err := list(ctx, opts)
+			if err != nil {
+				return "", err
+			}
+			for i := range items {
+				if err = fn(&items[i]); err != nil {
+					return "", err
+				}
+			}
+			return next, nil
+		})
+	}
+}
+
+func pagedDeployments(k8 *k8client.K8s) source[appsv1.Deployment] {
+	client := k8.Clientset.AppsV1().Deployments(k8.GetNamespace())
+	return paged(func(ctx context.Context, opts metav1.ListOptions) ([]appsv1.Deployment, string, error) {
+		list, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+}
+
+func pagedServices(k8 *k8client.K8s) source[corev1.Service] {
+	client := k8.Clientset.CoreV1().Services(k8.GetNamespace())
+	return paged(func(ctx context.Context, opts metav1.ListOptions) ([]corev1.Service, string, error) {
+		list, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+}
+
+func pagedSecrets(k8 *k8client.K8s) source[corev1.Secret] {
+	client := k8.Clientset.CoreV1().Secrets(k8.GetNamespace())
+	return paged(func(ctx context.Context, opts metav1.ListOptions) ([]corev1.Secret, string, error) {
+		list, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+}
+
+func pagedConfigMaps(k8 *k8client.K8s) source[corev1.ConfigMap] {
+	client := k8.Clientset.CoreV1().ConfigMaps(k8.GetNamespace())
+	return paged(func(ctx context.Context, opts metav1.ListOptions) ([]corev1.ConfigMap, string, error) {
+		list, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+}
+
+func pagedServiceAccounts(k8 *k8client.K8s) source[corev1.ServiceAccount] {
+	client := k8.Clientset.CoreV1().ServiceAccounts(k8.GetNamespace())
+	return paged(func(ctx context.Context, opts metav1.ListOptions) ([]corev1.ServiceAccount, string, error) {
+		list, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+}
+
+func pagedPersistentVolumeClaims(k8 *k8client.K8s) source[corev1.PersistentVolumeClaim] {
+	client := k8.Clientset.CoreV1().PersistentVolumeClaims(k8.GetNamespace())
+	return paged(func(ctx context.Context, opts metav1.ListOptions) ([]corev1.PersistentVolumeClaim, string, error) {
+		list, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+}
+
+// sliceSource is the source of objects already in memory, e.g. a snapshot
+func sliceSource[T any](items []T) source[T] {
+	return func(ctx context.Context, fn func(*T) error) error {
+		for i := range items {
+			if err := fn(&items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// eachMetadata calls fn with the metadata of each object of gvr page by page
+func eachMetadata(ctx context.Context, k8 *k8client.K8s, gvr schema.GroupVersionResource, fieldSelector string,
+	fn func(*metav1.PartialObjectMetadata)) error {
+	if k8.Metadata == nil {
+		return fmt.Errorf("no metadata client of namespace %s", k8.GetNamespace())
+	}
+	client := k8.Metadata.Resource(gvr).Namespace(k8.GetNamespace())
+	err := eachPage(ctx, metav1.ListOptions{FieldSelector: fieldSelector}, func(lo metav1.ListOptions) (string, error) {
+		list, err := client.List(ctx, lo)
+		if err != nil {
+			return "", err
+		}
+		for i := range list.Items {
+			fn(&list.Items[i])
+		}
+		return list.Continue, nil
+	})
+	if err != nil {
+		return fmt.Errorf("list %s of %s failed: %w", gvr.Resource, k8.GetNamespace(), err)
+	}
+	return nil
+}
+
+// dstObject is what is kept in memory of a destination object
+type dstObject struct {
+	hash    string // source hash, empty when never synced
+	wave    int
+	changed bool // changed by another field manager since k8sync wrote it
+}
+
+// dstObjects lists the metadata of destination objects selected by opts, by name
+func dstObjects(ctx context.Context, k8 *k8client.K8s, gvr schema.GroupVersionResource, fieldSelector string, opts *Options) (map[string]dstObject, error) {
+	objects := make(map[string]dstObject)
+	err := eachMetadata(ctx, k8, gvr, fieldSelector, func(m *metav1.PartialObjectMetadata) {
+		if opts.selected(m.Name) {
+			objects[m.Name] = dstObject{
+				hash:    m.Annotations[sourceHashAnnotation],
+				wave:    objectWave(m),
+				changed: !othersChange(m.ManagedFields).IsZero(),
+			}
+		}
+	})
+	return objects, err
+}
+
+// stampHash sets the hash of the filtered and transformed source object obj on it and returns the hash,
+// a destination object with the same hash needs no update. the fields ignored by rules are not hashed
+func stampHash(obj metav1.Object, rules []IgnoreRule) (string, error) {
+	annotations := obj.GetAnnotations()
+	delete(annotations, sourceHashAnnotation)
+	var v interface{} = obj
+	if len(rules) > 0 {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err == nil {
+			err = stripIgnored(u, rules)
+		}
+		if err != nil {
+			return "", fmt.Errorf("hash %s failed: %w", obj.GetName(), err)
+		}
+		v = u
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("hash %s failed: %w", obj.GetName(), err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[sourceHashAnnotation] = hash
+	obj.SetAnnotations(annotations)
+	return hash, nil
+}
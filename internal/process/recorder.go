@@ -0,0 +1,21 @@
+package process
+
+import (
+	"k8sync/internal/metrics"
+	"k8sync/pkg/logger"
+)
+
+// Recorder receives the result of each object applied to the destination
+type Recorder interface {
+	Record(kind, name, action string, err error)
+}
+
+// recordResult updates the sync metrics, then passes the result to rec,
+// it is called by the workers concurrently so rec must be safe for concurrent use
+func recordResult(rec Recorder, kind, name, action string, err error) {
+	if err != nil {
+		logger.Errorf("  %s %s %s failed: %s", action, kind, name, err)
+	}
+	metrics.ObserveSync(kind, action, err)
+	rec.Record(kind, name, action, err)
+}
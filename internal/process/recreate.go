@@ -0,0 +1,71 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"k8sync/internal/config"
+	"k8sync/pkg/logger"
+)
+
+const (
+	ImmutableSkip     = "skip"
+	ImmutableRecreate = "recreate"
+	ImmutableOrphan   = "orphan"
+
+	recreateTimeout = 2 * time.Minute // wait for the old object to be gone
+)
+
+// isImmutable reports whether err rejects an update changing an immutable field
+func isImmutable(err error) bool {
+	if !apierrors.IsInvalid(err) {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "immutable") || strings.Contains(msg, "may not change once set")
+}
+
+// immutablePolicy returns the sync.on-immutable policy of kind
+func immutablePolicy(kind string) string {
+	if policy, ok := config.Current().Sync.OnImmutable[kind]; ok {
+		return policy
+	}
+	return ImmutableSkip
+}
+
+// recreate deletes the destination object of obj and creates obj again by the policy of kind,
+// updateErr is returned when the policy skips the object
+func recreate[T any, PT object[T]](ctx context.Context, kind string, client objectClient[PT], obj PT, updateErr error) error {
+	name := obj.GetName()
+	propagation := metav1.DeletePropagationBackground
+	switch immutablePolicy(kind) {
+	case ImmutableRecreate:
+	case ImmutableOrphan:
+		propagation = metav1.DeletePropagationOrphan
+	default:
+		return fmt.Errorf("immutable field changed, skipped by sync.on-immutable: %w", updateErr)
+	}
+	logger.Infof("  recreate %s: %s, %s", kind, name, updateErr)
+	err := client.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete for recreate failed: %w", err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, time.Second, recreateTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("wait for deletion failed: %w", err)
+	}
+	_, err = client.Create(ctx, obj, metav1.CreateOptions{FieldManager: fieldManager})
+	return err
+}
@@ -0,0 +1,151 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"k8sync/internal/k8s/utils"
+)
+
+// Summary is a Recorder which counts the results of each kind and action,
+// and keeps every failure
+type Summary struct {
+	mu        sync.Mutex
+	counts    map[string]map[string]int // kind -> action -> succeeded
+	failures  []Failure
+	dangling  []Reference
+	conflicts []Conflict
+}
+
+// Conflict is an object whose fields changed both in the source and in the destination
+type Conflict struct {
+	Kind   string
+	Name   string
+	Fields []string
+}
+
+// Failure is an object which failed to sync
+type Failure struct {
+	Kind   string
+	Name   string
+	Action string
+	Err    error
+}
+
+func NewSummary() *Summary {
+	return &Summary{counts: make(map[string]map[string]int)}
+}
+
+func (s *Summary) Record(kind, name, action string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failures = append(s.failures, Failure{Kind: kind, Name: name, Action: action, Err: err})
+		return
+	}
+	if s.counts[kind] == nil {
+		s.counts[kind] = make(map[string]int)
+	}
+	s.counts[kind][action]++
+}
+
+// RecordDangling keeps a reference missing in the source
+func (s *Summary) RecordDangling(ref Reference) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dangling = append(s.dangling, ref)
+}
+
+// RecordConflict keeps the fields of an object changed on both sides
+func (s *Summary) RecordConflict(kind, name string, fields []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflicts = append(s.conflicts, Conflict{Kind: kind, Name: name, Fields: fields})
+}
+
+// Conflicts returns the objects merged with conflicts
+func (s *Summary) Conflicts() []Conflict {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Conflict(nil), s.conflicts...)
+}
+
+// Succeeded returns the number of objects synced, rollbacks are not counted
+func (s *Summary) Succeeded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, actions := range s.counts {
+		for action, c := range actions {
+			if action != utils.EventTypeRollback {
+				n += c
+			}
+		}
+	}
+	return n
+}
+
+// Failures returns the objects failed in the order they failed
+func (s *Summary) Failures() []Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Failure(nil), s.failures...)
+}
+
+// Print writes a table of the results of each kind, followed by the failures, the dangling references and the conflicts
+func (s *Summary) Print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failed := make(map[string]int)
+	kinds := make([]string, 0, len(s.counts))
+	for kind := range s.counts {
+		kinds = append(kinds, kind)
+	}
+	for _, f := range s.failures {
+		if failed[f.Kind] == 0 && s.counts[f.Kind] == nil {
+			kinds = append(kinds, f.Kind)
+		}
+		failed[f.Kind]++
+	}
+	sort.Strings(kinds)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tCREATED\tUPDATED\tRECREATED\tDELETED\tROLLED BACK\tFAILED")
+	for _, kind := range kinds {
+		c := s.counts[kind]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", kind, c[utils.EventTypeCreate], c[utils.EventTypeUpdate],
+			c[utils.EventTypeRecreate], c[utils.EventTypeDelete], c[utils.EventTypeRollback], failed[kind])
+	}
+	tw.Flush()
+	if len(s.failures) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tNAME\tACTION\tERROR")
+		for _, f := range s.failures {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Kind, f.Name, f.Action, f.Err)
+		}
+		tw.Flush()
+	}
+	if len(s.dangling) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "DEPLOYMENT\tMISSING KIND\tMISSING NAME")
+		for _, ref := range s.dangling {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", ref.From, ref.Kind, ref.Name)
+		}
+		tw.Flush()
+	}
+	if len(s.conflicts) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tNAME\tCONFLICTING FIELDS")
+		for _, c := range s.conflicts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Kind, c.Name, strings.Join(c.Fields, ", "))
+		}
+		tw.Flush()
+	}
+}
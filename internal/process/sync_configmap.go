@@ -0,0 +1,35 @@
+package process
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+)
+
+// rootCAConfigMap is published into every namespace by the cluster itself
+const rootCAConfigMap = "kube-root-ca.crt"
+
+// configMapSyncer syncs the src config maps to the namespace of dstK8, srcNs is the namespace they come from
+func configMapSyncer(srcNs string, src source[corev1.ConfigMap], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	return typedSyncer(kindSpec[corev1.ConfigMap, *corev1.ConfigMap]{
+		kind:          "configmap",
+		resource:      configMapResource,
+		fieldSelector: "metadata.name!=" + rootCAConfigMap,
+		client:        dstK8.Clientset.CoreV1().ConfigMaps(dstK8.GetNamespace()),
+		prepare: func(c *corev1.ConfigMap) bool {
+			if c.Name == rootCAConfigMap {
+				return false
+			}
+			clearMeta(c)
+			opts.transform("configmap", c)
+			if config.Current().App.Yaml {
+				exportYaml(srcNs, "configmap", c)
+			}
+			return true
+		},
+	}, src, waves, dstK8, opts, rec)
+}
@@ -3,7 +3,6 @@ package process
 import (
 	"context"
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/printers"
 	"k8sync/internal/config"
@@ -12,76 +11,28 @@ import (
 	"os"
 )
 
-func SyncDeployment(srcK8 *k8client.K8s, dstK8 *k8client.K8s) error {
-	var err error
-	var srcList *appsv1.DeploymentList
-	var dstList *appsv1.DeploymentList
-
-	srcDeployClient := srcK8.Clientset.AppsV1().Deployments(srcK8.GetNamespace())
-	srcList, err = srcDeployClient.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
-
-	dstDeployClient := dstK8.Clientset.AppsV1().Deployments(dstK8.GetNamespace())
-	dstList, err = dstDeployClient.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
-
-	/* save destination deployment */
-	var ddMap = make(map[string]appsv1.Deployment)
-	var dcMap = make(map[string]corev1.Container)
-	for _, dd := range dstList.Items {
-		deployFilter(&dd)
-		ddMap[dd.Name] = dd
-		for _, dc := range dd.Spec.Template.Spec.Containers {
-			dcMap[dd.Name+"-"+dc.Name] = dc
-		}
-	}
+// SyncDeployment syncs the deployments of the namespace of srcK8 to the namespace of dstK8, the result of each object is recorded in rec
+func SyncDeployment(ctx context.Context, srcK8 *k8client.K8s, dstK8 *k8client.K8s, opts *Options, rec Recorder) error {
+	ns := srcK8.GetNamespace()
+	return syncWaves(ctx, ns, deploymentSyncer(ns, pagedDeployments(srcK8), metadataWaves(srcK8, deploymentResource, "", opts), dstK8, opts, rec))
+}
 
-	/* compare source and destination deployment */
-	logger.Infof("sync deployment")
-	for _, sd := range srcList.Items {
-		deployFilter(&sd)
-		if config.GetBool("app.yaml") {
-			exportDeployYaml(srcK8.GetNamespace(), &sd)
-		}
-		if _, ok := ddMap[sd.Name]; !ok {
-			logger.Infof("  create deployment: %s", sd.Name)
-			_, err = dstDeployClient.Create(context.TODO(), &sd, metav1.CreateOptions{})
-			if err != nil {
-				return err
-			}
-		} else {
-			logger.Infof("  update deployment: %s", sd.Name)
-			for _, sc := range sd.Spec.Template.Spec.Containers {
-				if _, ok := dcMap[sd.Name+"-"+sc.Name]; !ok {
-					logger.Infof("    add container: %s", sc.Name)
-				}else {
-					if sc.Image != dcMap[sd.Name+"-"+sc.Name].Image {
-						logger.Infof("    update container: %s's image: %s", sc.Name, sc.Image)
-					}
-				}
+// deploymentSyncer syncs the src deployments to the namespace of dstK8, srcNs is the namespace they come from
+func deploymentSyncer(srcNs string, src source[appsv1.Deployment], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	return typedSyncer(kindSpec[appsv1.Deployment, *appsv1.Deployment]{
+		kind:     "deployment",
+		resource: deploymentResource,
+		client:   dstK8.Clientset.AppsV1().Deployments(dstK8.GetNamespace()),
+		prepare: func(d *appsv1.Deployment) bool {
+			deployFilter(d)
+			opts.transformDeployment(d)
+			if config.Current().App.Yaml {
+				exportDeployYaml(srcNs, d)
 			}
-			_, err = dstDeployClient.Update(context.TODO(), &sd, metav1.UpdateOptions{})
-			if err != nil {
-				return err
-			}
-			delete(ddMap, sd.Name)
-		}
-	}
-
-	/* delete destination deployment */
-	for _, dd := range ddMap {
-		logger.Infof("  delete deployment: %s", dd.Name)
-		err = dstDeployClient.Delete(context.TODO(), dd.Name, metav1.DeleteOptions{})
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+			return true
+		},
+	}, src, waves, dstK8, opts, rec)
 }
 
 func deployFilter(d *appsv1.Deployment) {
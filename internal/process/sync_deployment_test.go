@@ -0,0 +1,169 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testDeployment(ns, image string) *appsv1.Deployment {
+	replicas := int32(2)
+	labels := map[string]string{"app": "web"}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "web",
+			Namespace:         ns,
+			Labels:            labels,
+			UID:               "5b0d7c3e-0000-4000-8000-000000000001",
+			ResourceVersion:   "42",
+			CreationTimestamp: metav1.Unix(1700000000, 0),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "web",
+						Image: image,
+						Ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+					}},
+				},
+			},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 2, ReadyReplicas: 2},
+	}
+}
+
+// editDeployment changes the deployment web of namespace ns with edit, as manager when it is not empty
+func editDeployment(ctx context.Context, t *testing.T, cs *fake.Clientset, ns, manager string, edit func(d *appsv1.Deployment)) {
+	t.Helper()
+	d, err := cs.AppsV1().Deployments(ns).Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(d)
+	if manager != "" {
+		editedBy(d, manager)
+	}
+	if _, err = cs.AppsV1().Deployments(ns).Update(ctx, d, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncDeployment(t *testing.T) {
+	stale := testDeployment("dst", "nginx:1.25")
+	stale.Labels = map[string]string{"app": "web", "owner": "ops"}
+	stale.Annotations = map[string]string{sourceHashAnnotation: "stale"}
+	threeWay := &Options{ThreeWayMerge: true}
+	ignoreReplicas := &Options{IgnoreDifferences: []IgnoreRule{{Kind: "deployment", JSONPointers: []string{"/spec/replicas"}}}}
+
+	cases := []syncCase{
+		{name: "create", writes: 1},
+		{name: "update", dst: []runtime.Object{stale}, writes: 1},
+		{name: "unchanged", edit: func(context.Context, *testing.T, *fake.Clientset, *fake.Clientset) {}},
+		{
+			name: "drift",
+			edit: func(ctx context.Context, t *testing.T, _, cs *fake.Clientset) {
+				editDeployment(ctx, t, cs, "dst", "kubectl-edit", func(d *appsv1.Deployment) {
+					d.Spec.Template.Spec.Containers[0].Image = "nginx:edited"
+				})
+			},
+			writes: 1,
+			gets:   1,
+		},
+		{
+			name: "controller_change",
+			edit: func(ctx context.Context, t *testing.T, _, cs *fake.Clientset) {
+				editDeployment(ctx, t, cs, "dst", "kube-controller-manager", func(d *appsv1.Deployment) {
+					metav1.SetMetaDataAnnotation(&d.ObjectMeta, "deployment.kubernetes.io/revision", "2")
+				})
+			},
+			writes: 1, // the checked-at stamp
+			gets:   1,
+		},
+		{
+			name: "ignored",
+			opts: ignoreReplicas,
+			edit: func(ctx context.Context, t *testing.T, src, dst *fake.Clientset) {
+				editDeployment(ctx, t, src, "src", "", func(d *appsv1.Deployment) {
+					d.Spec.Template.Spec.Containers[0].Image = "nginx:1.28"
+				})
+				editDeployment(ctx, t, dst, "dst", "hpa", func(d *appsv1.Deployment) { *d.Spec.Replicas = 5 })
+			},
+			writes: 1,
+			gets:   1,
+		},
+		{
+			name: "ignored_drift",
+			opts: ignoreReplicas,
+			edit: func(ctx context.Context, t *testing.T, _, dst *fake.Clientset) {
+				editDeployment(ctx, t, dst, "dst", "hpa", func(d *appsv1.Deployment) { *d.Spec.Replicas = 5 })
+			},
+			writes: 1, // the checked-at stamp
+			gets:   1,
+		},
+		{
+			name: "three_way",
+			opts: threeWay,
+			edit: func(ctx context.Context, t *testing.T, src, dst *fake.Clientset) {
+				editDeployment(ctx, t, src, "src", "", func(d *appsv1.Deployment) {
+					d.Spec.Template.Spec.Containers[0].Image = "nginx:1.28"
+				})
+				editDeployment(ctx, t, dst, "dst", "kubectl-edit", func(d *appsv1.Deployment) {
+					d.Labels["owner"] = "ops"
+				})
+			},
+			writes: 1,
+			gets:   1,
+		},
+		{
+			name: "three_way_conflict",
+			opts: threeWay,
+			edit: func(ctx context.Context, t *testing.T, src, dst *fake.Clientset) {
+				editDeployment(ctx, t, src, "src", "", func(d *appsv1.Deployment) { *d.Spec.Replicas = 3 })
+				editDeployment(ctx, t, dst, "dst", "kubectl-scale", func(d *appsv1.Deployment) { *d.Spec.Replicas = 5 })
+			},
+			writes:    1,
+			gets:      1,
+			conflicts: 1,
+		},
+		{
+			name: "three_way_update_conflict",
+			opts: threeWay,
+			edit: func(ctx context.Context, t *testing.T, src, dst *fake.Clientset) {
+				editDeployment(ctx, t, src, "src", "", func(d *appsv1.Deployment) {
+					d.Spec.Template.Spec.Containers[0].Image = "nginx:1.28"
+				})
+				// the destination changes between the merge and the update once
+				conflicted := false
+				dst.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					if conflicted {
+						return false, nil, nil
+					}
+					conflicted = true
+					return true, nil, apierrors.NewConflict(appsv1.Resource("deployments"), "web", errors.New("changed"))
+				})
+			},
+			writes: 2,
+			gets:   2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runSyncCase(t, "deployment", c, []runtime.Object{testDeployment("src", "nginx:1.27")}, SyncDeployment,
+				func(ctx context.Context, cs *fake.Clientset) (runtime.Object, error) {
+					return cs.AppsV1().Deployments("dst").List(ctx, metav1.ListOptions{})
+				})
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package process
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+)
+
+// claimSyncer syncs the src persistent volume claims to the namespace of dstK8, srcNs is the namespace they come from.
+// a claim is bound to a volume of the destination cluster, only its labels, annotations and storage request are updated
+func claimSyncer(srcNs string, src source[corev1.PersistentVolumeClaim], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	client := dstK8.Clientset.CoreV1().PersistentVolumeClaims(dstK8.GetNamespace())
+	return typedSyncer(kindSpec[corev1.PersistentVolumeClaim, *corev1.PersistentVolumeClaim]{
+		kind:     "persistentvolumeclaim",
+		resource: claimResource,
+		client:   client,
+		prepare: func(c *corev1.PersistentVolumeClaim) bool {
+			claimFilter(c)
+			opts.transform("persistentvolumeclaim", c)
+			if config.Current().App.Yaml {
+				exportYaml(srcNs, "persistentvolumeclaim", c)
+			}
+			return true
+		},
+		update: func(ctx context.Context, c *corev1.PersistentVolumeClaim, hash string) error {
+			return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				dc, err := client.Get(ctx, c.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				dc.Labels = c.Labels
+				dc.Annotations = merge(dc.Annotations, c.Annotations)
+				dc.Spec.Resources.Requests = c.Spec.Resources.Requests
+				_, err = client.Update(ctx, dc, metav1.UpdateOptions{FieldManager: fieldManager})
+				return err
+			})
+		},
+	}, src, waves, dstK8, opts, rec)
+}
+
+// claimFilter drops the binding of a claim to its source volume
+func claimFilter(c *corev1.PersistentVolumeClaim) {
+	clearMeta(c)
+	c.Status = corev1.PersistentVolumeClaimStatus{}
+	c.Spec.VolumeName = ""
+	for k := range c.Annotations {
+		if strings.HasPrefix(k, "pv.kubernetes.io/") || strings.HasPrefix(k, "volume.kubernetes.io/") ||
+			strings.HasPrefix(k, "volume.beta.kubernetes.io/") {
+			delete(c.Annotations, k)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"k8sync/gen/proto/k8sync/v1"
+	"k8sync/internal/auth"
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/internal/tracing"
+	"k8sync/pkg/logger"
+)
+
+const (
+	RunStateRunning   = "running"
+	RunStateSucceeded = "succeeded"
+	RunStateFailed    = "failed"
+	RunStateCancelled = "cancelled"
+
+	maxSyncRuns    = 100 // finished runs kept in memory
+	progressBuffer = 256 // progress events buffered for each watcher
+)
+
+var tracer = tracing.Tracer("process")
+
+// SyncKinds are the object kinds which can be synced to a cluster,
+// secrets only come with the deployments referencing them
+var SyncKinds = []string{"deployment", "service", "configmap", "serviceaccount", "persistentvolumeclaim"}
+
+// ExportKinds are the object kinds which can be exported to yaml files, git and backups
+var ExportKinds = append([]string{"secret"}, SyncKinds...)
+
+// Sync implements the protobuf interface, it runs syncs on demand
+type Sync struct {
+	pb.UnimplementedSyncServiceServer
+	ctx      context.Context // parent of all runs, cancelled when daemon stops
+	srcK8    *k8client.K8s
+	dstK8    *k8client.K8s
+	mu       *sync.RWMutex
+	runs     map[string]*syncRun
+	order    []string // run ids, oldest first
+	seq      int
+	policies Policies // nil without the syncpolicy controller
+}
+
+// Policies tells which SyncPolicy syncs a namespace of the source cluster
+type Policies interface {
+	// Covering returns the namespace/name of an active policy syncing namespace, empty if none
+	Covering(namespace string) string
+}
+
+// syncRun is one sync run and its watchers
+type syncRun struct {
+	mu       sync.Mutex
+	run      *pb.SyncRun
+	cancel   context.CancelFunc
+	watchers map[chan *pb.SyncProgress]struct{}
+}
+
+// NewSync initializes a new Sync struct, runs stop when ctx is done.
+func NewSync(ctx context.Context, srcK8 *k8client.K8s, dstK8 *k8client.K8s) *Sync {
+	return &Sync{
+		ctx:   ctx,
+		srcK8: srcK8,
+		dstK8: dstK8,
+		mu:    &sync.RWMutex{},
+		runs:  make(map[string]*syncRun),
+	}
+}
+
+// SetPolicies makes runs of namespaces synced by a SyncPolicy rejected
+func (s *Sync) SetPolicies(policies Policies) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+}
+
+// TriggerSync starts a sync run in background, a namespace synced by a running run
+// or by a SyncPolicy is rejected
+func (s *Sync) TriggerSync(ctx context.Context, req *pb.TriggerSyncRequest) (*pb.SyncRun, error) {
+	namespaces := req.GetNamespaces()
+	if len(namespaces) == 0 {
+		namespaces = []string{config.Current().Src.Namespace}
+	}
+	for _, ns := range namespaces {
+		if ns == "" {
+			return nil, status.Error(codes.InvalidArgument, "namespace is empty")
+		}
+	}
+	kinds := req.GetKinds()
+	if len(kinds) == 0 {
+		kinds = syncKinds(config.Current().Src.Objects)
+	}
+	for _, kind := range kinds {
+		if !contains(SyncKinds, kind) {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported object kind: %s", kind)
+		}
+	}
+
+	runCtx, cancel := SyncContext(s.ctx)
+	r := &syncRun{
+		run: &pb.SyncRun{
+			Namespaces: namespaces,
+			Kinds:      kinds,
+			State:      RunStateRunning,
+			Started:    timestamppb.Now(),
+		},
+		cancel:   cancel,
+		watchers: make(map[chan *pb.SyncProgress]struct{}),
+	}
+	if err := s.add(r); err != nil {
+		cancel()
+		return nil, err
+	}
+	logger.Infof("start sync run %s by %s: namespaces %v, kinds %v", r.run.Id, auth.Caller(ctx), namespaces, kinds)
+	go s.execute(runCtx, r)
+	return r.snapshot(false), nil
+}
+
+// ListRuns lists all runs without object results, newest first
+func (s *Sync) ListRuns(ctx context.Context, req *pb.ListRunsRequest) (*pb.ListRunsResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	resp := &pb.ListRunsResponse{}
+	for i := len(s.order) - 1; i >= 0; i-- {
+		resp.Runs = append(resp.Runs, s.runs[s.order[i]].snapshot(false))
+	}
+	return resp, nil
+}
+
+// GetRun returns a run with the result of each object
+func (s *Sync) GetRun(ctx context.Context, req *pb.GetRunRequest) (*pb.SyncRun, error) {
+	r, err := s.get(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return r.snapshot(true), nil
+}
+
+// CancelRun cancels a running run, finished runs are returned unchanged
+func (s *Sync) CancelRun(ctx context.Context, req *pb.CancelRunRequest) (*pb.SyncRun, error) {
+	r, err := s.get(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("cancel sync run %s by %s", req.GetId(), auth.Caller(ctx))
+	r.cancel()
+	return r.snapshot(false), nil
+}
+
+// WatchRun streams the result of each object until the run finishes,
+// results recorded before the call are sent first
+func (s *Sync) WatchRun(req *pb.WatchRunRequest, stream pb.SyncService_WatchRunServer) error {
+	r, err := s.get(req.GetId())
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	id, state := r.run.Id, r.run.State
+	var history []*pb.SyncProgress
+	for _, res := range r.run.Results {
+		history = append(history, &pb.SyncProgress{RunId: id, State: RunStateRunning, Result: res})
+	}
+	var ch chan *pb.SyncProgress
+	if state == RunStateRunning {
+		ch = make(chan *pb.SyncProgress, progressBuffer)
+		r.watchers[ch] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	for _, p := range history {
+		if err = stream.Send(p); err != nil {
+			r.unwatch(ch)
+			return err
+		}
+	}
+	if ch == nil {
+		return stream.Send(&pb.SyncProgress{RunId: id, State: state})
+	}
+	for {
+		select {
+		case p, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err = stream.Send(p); err != nil {
+				r.unwatch(ch)
+				return err
+			}
+		case <-stream.Context().Done():
+			r.unwatch(ch)
+			return stream.Context().Err()
+		}
+	}
+}
+
+// add registers r unless one of its namespaces is synced by a running run or a policy
+func (s *Sync) add(r *syncRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ns := range r.run.Namespaces {
+		if s.policies != nil {
+			if key := s.policies.Covering(ns); key != "" {
+				return status.Errorf(codes.FailedPrecondition, "namespace %s is synced by syncpolicy %s", ns, key)
+			}
+		}
+		for _, id := range s.order {
+			if running := s.runs[id]; running.state() == RunStateRunning && contains(running.run.Namespaces, ns) {
+				return status.Errorf(codes.Aborted, "namespace %s is synced by running run %s", ns, id)
+			}
+		}
+	}
+	s.seq++
+	r.run.Id = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), s.seq)
+	s.runs[r.run.Id] = r
+	s.order = append(s.order, r.run.Id)
+
+	// drop the oldest finished runs
+	for i := 0; len(s.order) > maxSyncRuns && i < len(s.order); {
+		old := s.runs[s.order[i]]
+		if old.state() == RunStateRunning {
+			i++
+			continue
+		}
+		delete(s.runs, s.order[i])
+		s.order = append(s.order[:i], s.order[i+1:]...)
+	}
+	return nil
+}
+
+func (s *Sync) get(id string) (*syncRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.runs[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "sync run %s not found", id)
+	}
+	return r, nil
+}
+
+func (s *Sync) execute(ctx context.Context, r *syncRun) {
+	var errs []error
+	defer func() {
+		r.finish(errors.Join(errs...))
+		r.cancel()
+	}()
+	opts := OptionsFromConfig()
+	for _, ns := range r.run.Namespaces {
+		srcK8 := s.srcK8.WithNamespace(ns)
+		dstK8 := s.dstK8.WithNamespace(dstNamespace(ns))
+		rec := &runRecorder{run: r, namespace: ns}
+		if err := SyncNamespace(ctx, srcK8, dstK8, r.run.Kinds, opts, rec); err != nil {
+			errs = append(errs, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// SyncNamespace syncs kinds from the namespace of srcK8 to the namespace of dstK8 in sync waves, kinds
+// only exported are skipped. a kind failed to list does not stop the others, their errors are joined.
+// objects failed are recorded in rec only, with opts.Dependencies the objects referenced by
+// the synced deployments are synced too. with opts.Verify the deployments and services written are verified
+// after the sync, those failed are recorded in rec
+func SyncNamespace(ctx context.Context, srcK8 *k8client.K8s, dstK8 *k8client.K8s, kinds []string, opts *Options, rec Recorder) error {
+	if opts != nil && opts.Verify != nil {
+		o := *opts
+		o.verification = &verification{}
+		opts = &o
+	}
+	kinds = syncKinds(kinds)
+	kinds, kindOpts, err := withDependencies(ctx, srcK8, kinds, opts, rec)
+	if err != nil {
+		return err
+	}
+	syncers := make([]*kindSyncer, 0, len(kinds))
+	for _, kind := range kinds {
+		o, ok := kindOpts[kind]
+		if !ok {
+			o = opts
+		}
+		s, err := kindSyncerFor(kind, srcK8, dstK8, o, rec)
+		if err != nil {
+			return err
+		}
+		syncers = append(syncers, s)
+	}
+	err = syncWaves(ctx, srcK8.GetNamespace(), syncers...)
+	if opts != nil && opts.verification != nil && ctx.Err() == nil {
+		opts.verification.verify(ctx, dstK8, opts.Verify, rec)
+	}
+	return err
+}
+
+// syncKinds drops the kinds of src.objects which are exported only, like secrets
+func syncKinds(kinds []string) []string {
+	synced := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		if contains(SyncKinds, kind) || !contains(ExportKinds, kind) {
+			synced = append(synced, kind)
+		}
+	}
+	return synced
+}
+
+// SyncContext returns a child of ctx with the deadline of sync.timeout
+func SyncContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := config.Current().Sync.Timeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// dstNamespace maps a source namespace to its destination namespace
+func dstNamespace(srcNs string) string {
+	settings := config.Current()
+	if srcNs == settings.Src.Namespace && settings.Dst.Namespace != "" {
+		return settings.Dst.Namespace
+	}
+	return srcNs
+}
+
+func (r *syncRun) state() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.run.State
+}
+
+func (r *syncRun) snapshot(withResults bool) *pb.SyncRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := proto.Clone(r.run).(*pb.SyncRun)
+	if !withResults {
+		run.Results = nil
+	}
+	return run
+}
+
+func (r *syncRun) record(res *pb.SyncObjectResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Results = append(r.run.Results, res)
+	if res.Error == "" {
+		r.run.Succeeded++
+	} else {
+		r.run.Failed++
+	}
+	r.notify(&pb.SyncProgress{RunId: r.run.Id, State: r.run.State, Result: res})
+}
+
+func (r *syncRun) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err == nil && r.run.Failed > 0:
+		r.run.State = RunStateFailed
+		r.run.Error = fmt.Sprintf("%d objects failed", r.run.Failed)
+	case err == nil:
+		r.run.State = RunStateSucceeded
+	case errors.Is(err, context.Canceled):
+		r.run.State = RunStateCancelled
+		r.run.Error = err.Error()
+	default:
+		r.run.State = RunStateFailed
+		r.run.Error = err.Error()
+	}
+	r.run.Finished = timestamppb.Now()
+	logger.Infof("sync run %s %s: %d succeeded, %d failed", r.run.Id, r.run.State, r.run.Succeeded, r.run.Failed)
+
+	r.notify(&pb.SyncProgress{RunId: r.run.Id, State: r.run.State})
+	for ch := range r.watchers {
+		close(ch)
+		delete(r.watchers, ch)
+	}
+}
+
+// notify sends p to all watchers, a slow watcher misses events instead of
+// blocking the run. r.mu must be held.
+func (r *syncRun) notify(p *pb.SyncProgress) {
+	for ch := range r.watchers {
+		select {
+		case ch <- p:
+		default:
+			logger.Warnf("sync run %s watcher is too slow, drop progress event", r.run.Id)
+		}
+	}
+}
+
+func (r *syncRun) unwatch(ch chan *pb.SyncProgress) {
+	if ch == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watchers[ch]; ok {
+		delete(r.watchers, ch)
+		close(ch)
+	}
+}
+
+// runRecorder records object results of one namespace into a run
+type runRecorder struct {
+	run       *syncRun
+	namespace string
+}
+
+func (rr *runRecorder) Record(kind, name, action string, err error) {
+	res := &pb.SyncObjectResult{
+		Namespace: rr.namespace,
+		Kind:      kind,
+		Name:      name,
+		Action:    action,
+		Time:      timestamppb.Now(),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	rr.run.record(res)
+}
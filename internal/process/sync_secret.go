@@ -0,0 +1,46 @@
+package process
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+)
+
+// secretSyncer syncs the src secrets to the namespace of dstK8, srcNs is the namespace they come from.
+// secrets are only synced as dependencies of deployments
+func secretSyncer(srcNs string, src source[corev1.Secret], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	return typedSyncer(kindSpec[corev1.Secret, *corev1.Secret]{
+		kind:          "secret",
+		resource:      secretResource,
+		fieldSelector: notTokenSecrets,
+		client:        dstK8.Clientset.CoreV1().Secrets(dstK8.GetNamespace()),
+		prepare: func(s *corev1.Secret) bool {
+			if !secretSyncable(s) {
+				return false
+			}
+			secretFilter(s)
+			opts.transform("secret", s)
+			if config.Current().App.Yaml {
+				exportYaml(srcNs, "secret", s)
+			}
+			return true
+		},
+	}, src, waves, dstK8, opts, rec)
+}
+
+// secretSyncable skips the secrets generated by the cluster itself
+func secretSyncable(s *corev1.Secret) bool {
+	return s.Type != corev1.SecretTypeServiceAccountToken
+}
+
+func secretFilter(s *corev1.Secret) {
+	s.Namespace = ""
+	s.CreationTimestamp = metav1.Time{}
+	s.ManagedFields = []metav1.ManagedFieldsEntry{}
+	s.UID = ""
+	s.ResourceVersion = ""
+}
@@ -5,95 +5,148 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/util/retry"
 	"k8sync/internal/config"
 	k8client "k8sync/internal/k8s/client"
 	"k8sync/pkg/logger"
 	"os"
+	"strconv"
+	"strings"
 )
 
-func SyncService(srcK8 *k8client.K8s, dstK8 *k8client.K8s) error {
-	var err error
-	var srcList *corev1.ServiceList
-	var dstList *corev1.ServiceList
+// SyncService syncs the services of the namespace of srcK8 to the namespace of dstK8, the result of each object is recorded in rec
+func SyncService(ctx context.Context, srcK8 *k8client.K8s, dstK8 *k8client.K8s, opts *Options, rec Recorder) error {
+	ns := srcK8.GetNamespace()
+	return syncWaves(ctx, ns, serviceSyncer(ns, pagedServices(srcK8), metadataWaves(srcK8, serviceResource, "", opts), dstK8, opts, rec))
+}
 
-	srcServiceClient := srcK8.Clientset.CoreV1().Services(srcK8.GetNamespace())
-	srcList, err = srcServiceClient.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
+// serviceSyncer syncs the src services to the namespace of dstK8, srcNs is the namespace they come from,
+// a changed service replaces the spec and metadata of the destination service, keeping what the destination allocated
+func serviceSyncer(srcNs string, src source[corev1.Service], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	client := dstK8.Clientset.CoreV1().Services(dstK8.GetNamespace())
+	return typedSyncer(kindSpec[corev1.Service, *corev1.Service]{
+		kind:     "service",
+		resource: serviceResource,
+		client:   client,
+		prepare: func(s *corev1.Service) bool {
+			serviceFilter(s)
+			adaptService(s, config.Current().Dst.Service)
+			opts.transform("service", s)
+			if config.Current().App.Yaml {
+				exportServiceYaml(srcNs, s)
+			}
+			return true
+		},
+		update: func(ctx context.Context, s *corev1.Service, hash string) error {
+			return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				ds, err := client.Get(ctx, s.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				spec := *s.Spec.DeepCopy()
+				keepAllocated(&spec, &ds.Spec)
+				ds.Labels, ds.Annotations, ds.Spec = s.Labels, s.Annotations, spec
+				metav1.SetMetaDataAnnotation(&ds.ObjectMeta, sourceHashAnnotation, hash)
+				_, err = client.Update(ctx, ds, metav1.UpdateOptions{FieldManager: fieldManager})
+				return err
+			})
+		},
+	}, src, waves, dstK8, opts, rec)
+}
 
-	dstServiceClient := dstK8.Clientset.CoreV1().Services(dstK8.GetNamespace())
-	dstList, err = dstServiceClient.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return err
+func serviceFilter(s *corev1.Service) {
+	s.Namespace = ""
+	s.CreationTimestamp = metav1.Time{}
+	s.Status = corev1.ServiceStatus{}
+	s.ManagedFields = []metav1.ManagedFieldsEntry{}
+	s.UID = ""
+	s.ResourceVersion = ""
+	if s.Spec.ClusterIP != corev1.ClusterIPNone {
+		s.Spec.ClusterIP = ""
 	}
+	s.Spec.ClusterIPs = nil
+	s.Spec.IPFamilies = nil
+	s.Spec.HealthCheckNodePort = 0
+}
 
-	/* save destination deployment */
-	var dsMap = make(map[string]corev1.Service)     // destination service map
-	var dpMap = make(map[string]corev1.ServicePort) // destination service port map
-	for _, ds := range dstList.Items {
-		dsMap[ds.Name] = ds
-		for _, dp := range ds.Spec.Ports {
-			dpMap[ds.Name+"-"+dp.Name] = dp
+// adaptService applies the dst.service settings to a filtered source service
+func adaptService(s *corev1.Service, settings config.ServiceSettings) {
+	for k := range s.Annotations {
+		for _, prefix := range settings.DropAnnotations {
+			if strings.HasPrefix(k, prefix) {
+				delete(s.Annotations, k)
+				break
+			}
 		}
 	}
-
-	/* compare source and destination deployment */
-	logger.Infof("sync service")
-	for _, ss := range srcList.Items {
-		serviceFilter(&ss)
-		if config.GetBool("app.yaml") {
-			exportServiceYaml(srcK8.GetNamespace(), &ss)
-		}
-		if _, ok := dsMap[ss.Name]; !ok {
-			logger.Infof("  create service: %s", ss.Name)
-			_, err = dstServiceClient.Create(context.TODO(), &ss, metav1.CreateOptions{})
-			if err != nil {
-				return err
-			}
+	if s.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		if settings.LoadBalancer == "cluster-ip" {
+			s.Spec.Type = corev1.ServiceTypeClusterIP
+			s.Spec.LoadBalancerIP = ""
+			s.Spec.LoadBalancerSourceRanges = nil
+			s.Spec.LoadBalancerClass = nil
+			s.Spec.AllocateLoadBalancerNodePorts = nil
+			s.Spec.ExternalTrafficPolicy = ""
 		} else {
-			logger.Infof("  update service: %s", ss.Name)
-			for _, sp := range ss.Spec.Ports {
-				if _, ok := dpMap[ss.Name+"-"+sp.Name]; !ok {
-					logger.Infof("    add port: %s", ss.Name)
-				} else {
-					if sp.Port != dpMap[ss.Name+"-"+sp.Name].Port {
-						logger.Infof("    change port: %s from %d to %d",
-							sp.Name, dpMap[ss.Name+"-"+sp.Name].Port, sp.Port)
-					}
-				}
+			for k, v := range settings.LoadBalancerAnnotations {
+				metav1.SetMetaDataAnnotation(&s.ObjectMeta, k, v)
 			}
-			ds := dsMap[ss.Name]
-			ds.Spec.Ports = ss.Spec.Ports
-			_, err = dstServiceClient.Update(context.TODO(), &ds, metav1.UpdateOptions{})
-			if err != nil {
-				return err
-			}
-			delete(dsMap, ss.Name)
 		}
 	}
-
-	/* delete destination deployment */
-	for _, ds := range dsMap {
-		logger.Infof("  delete service: %s", ds.Name)
-		err = dstServiceClient.Delete(context.TODO(), ds.Name, metav1.DeleteOptions{})
-		if err != nil {
-			return err
+	for i := range s.Spec.Ports {
+		p := &s.Spec.Ports[i]
+		switch {
+		case !hasNodePorts(s.Spec.Type), settings.NodePorts == "strip":
+			p.NodePort = 0
+		case settings.NodePorts == "remap" && p.NodePort != 0:
+			p.NodePort = settings.NodePortMap[strconv.Itoa(int(p.NodePort))]
 		}
 	}
+}
 
-	return nil
+// keepAllocated copies into spec the cluster IPs, node ports and health check port the destination
+// allocated to its service dst, which spec leaves to allocate. an ExternalName service has none of them,
+// a service changed to ExternalName drops them and one changed from ExternalName gets them allocated
+func keepAllocated(spec, dst *corev1.ServiceSpec) {
+	if spec.Type == corev1.ServiceTypeExternalName {
+		spec.ClusterIP, spec.ClusterIPs = "", nil
+		spec.IPFamilies, spec.IPFamilyPolicy = nil, nil
+		spec.HealthCheckNodePort = 0
+		for i := range spec.Ports {
+			spec.Ports[i].NodePort = 0
+		}
+		return
+	}
+	if dst.Type == corev1.ServiceTypeExternalName {
+		return
+	}
+	if spec.ClusterIP == "" && dst.ClusterIP != corev1.ClusterIPNone {
+		spec.ClusterIP, spec.ClusterIPs = dst.ClusterIP, dst.ClusterIPs
+	}
+	if spec.IPFamilies == nil {
+		spec.IPFamilies = dst.IPFamilies
+	}
+	if spec.Type == corev1.ServiceTypeLoadBalancer && spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyLocal &&
+		spec.HealthCheckNodePort == 0 {
+		spec.HealthCheckNodePort = dst.HealthCheckNodePort
+	}
+	if !hasNodePorts(spec.Type) {
+		return
+	}
+	for i := range spec.Ports {
+		p := &spec.Ports[i]
+		for _, dp := range dst.Ports {
+			if p.NodePort == 0 && dp.Port == p.Port && dp.Protocol == p.Protocol {
+				p.NodePort = dp.NodePort
+			}
+		}
+	}
 }
 
-func serviceFilter(s *corev1.Service) {
-	s.Namespace = ""
-	s.CreationTimestamp = metav1.Time{}
-	s.Status = corev1.ServiceStatus{}
-	s.ManagedFields = []metav1.ManagedFieldsEntry{}
-	s.UID = ""
-	s.ResourceVersion = ""
-	s.Spec.ClusterIP = ""
-	s.Spec.ClusterIPs = nil
+// hasNodePorts tells whether services of type t get node ports
+func hasNodePorts(t corev1.ServiceType) bool {
+	return t == corev1.ServiceTypeNodePort || t == corev1.ServiceTypeLoadBalancer
 }
 
 func exportServiceYaml(ns string, s *corev1.Service) {
@@ -0,0 +1,84 @@
+package process
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testService(ns, clusterIP string) *corev1.Service {
+	policy := corev1.IPFamilyPolicySingleStack
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       ns,
+			Labels:          map[string]string{"app": "web"},
+			UID:             "5b0d7c3e-0000-4000-8000-000000000002",
+			ResourceVersion: "42",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                  corev1.ServiceTypeLoadBalancer,
+			Selector:              map[string]string{"app": "web"},
+			ClusterIP:             clusterIP,
+			ClusterIPs:            []string{clusterIP},
+			IPFamilies:            []corev1.IPFamily{corev1.IPv4Protocol},
+			IPFamilyPolicy:        &policy,
+			ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyLocal,
+			HealthCheckNodePort:   32000,
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       80,
+				TargetPort: intstr.FromInt32(8080),
+				NodePort:   30080,
+			}},
+		},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}},
+		}},
+	}
+}
+
+func TestSyncService(t *testing.T) {
+	stale := testService("dst", "10.96.0.10")
+	stale.Spec.HealthCheckNodePort = 31500
+	stale.Spec.Ports[0].TargetPort = intstr.FromInt32(9090)
+	stale.Annotations = map[string]string{sourceHashAnnotation: "stale"}
+
+	cases := []syncCase{
+		{name: "create", writes: 1},
+		{name: "update", dst: []runtime.Object{stale}, writes: 1, gets: 1},
+		{name: "unchanged", edit: func(context.Context, *testing.T, *fake.Clientset, *fake.Clientset) {}},
+		{
+			name: "external_name",
+			dst:  []runtime.Object{stale},
+			edit: func(ctx context.Context, t *testing.T, _, cs *fake.Clientset) {
+				s, err := cs.CoreV1().Services("dst").Get(ctx, "web", metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.Spec.Type, s.Spec.ExternalName = corev1.ServiceTypeExternalName, "web.example.com"
+				s.Spec.ClusterIP, s.Spec.ClusterIPs, s.Spec.Ports[0].NodePort = "", nil, 0
+				editedBy(s, "kubectl-edit")
+				if _, err = cs.CoreV1().Services("dst").Update(ctx, s, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			writes: 1,
+			gets:   2, // the drift check and the update
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runSyncCase(t, "service", c, []runtime.Object{testService("src", "10.0.0.5")}, SyncService,
+				func(ctx context.Context, cs *fake.Clientset) (runtime.Object, error) {
+					return cs.CoreV1().Services("dst").List(ctx, metav1.ListOptions{})
+				})
+		})
+	}
+}
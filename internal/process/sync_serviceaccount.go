@@ -0,0 +1,37 @@
+package process
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"k8sync/internal/config"
+	k8client "k8sync/internal/k8s/client"
+)
+
+// defaultServiceAccount is created in every namespace by the cluster itself
+const defaultServiceAccount = "default"
+
+// serviceAccountSyncer syncs the src service accounts to the namespace of dstK8, srcNs is the namespace they come from,
+// their token secrets belong to the source cluster and are not synced
+func serviceAccountSyncer(srcNs string, src source[corev1.ServiceAccount], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	return typedSyncer(kindSpec[corev1.ServiceAccount, *corev1.ServiceAccount]{
+		kind:          "serviceaccount",
+		resource:      accountResource,
+		fieldSelector: "metadata.name!=" + defaultServiceAccount,
+		client:        dstK8.Clientset.CoreV1().ServiceAccounts(dstK8.GetNamespace()),
+		prepare: func(a *corev1.ServiceAccount) bool {
+			if a.Name == defaultServiceAccount {
+				return false
+			}
+			clearMeta(a)
+			a.Secrets = nil
+			opts.transform("serviceaccount", a)
+			if config.Current().App.Yaml {
+				exportYaml(srcNs, "serviceaccount", a)
+			}
+			return true
+		},
+	}, src, waves, dstK8, opts, rec)
+}
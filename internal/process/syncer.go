@@ -0,0 +1,213 @@
+package process
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"k8sync/internal/gitops"
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/internal/k8s/utils"
+	"k8sync/pkg/logger"
+)
+
+// object is the pointer type of a typed object
+type object[T any] interface {
+	*T
+	metav1.Object
+	runtime.Object
+}
+
+// objectClient is the typed client of a kind in the destination namespace
+type objectClient[PT any] interface {
+	Create(ctx context.Context, obj PT, opts metav1.CreateOptions) (PT, error)
+	Update(ctx context.Context, obj PT, opts metav1.UpdateOptions) (PT, error)
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (PT, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (PT, error)
+}
+
+// kindSpec tells how the objects of a kind are synced
+type kindSpec[T any, PT object[T]] struct {
+	kind          string
+	resource      schema.GroupVersionResource
+	fieldSelector string // selects the destination objects k8sync may sync
+	client        objectClient[PT]
+	// prepare filters, transforms and exports a selected source object, false skips the object
+	prepare func(obj PT) bool
+	// update applies a changed source object to its destination object, nil to replace the object.
+	// an update rejected for an immutable field is handled by the sync.on-immutable policy of kind
+	update func(ctx context.Context, obj PT, hash string) error
+}
+
+// typedSyncer syncs the src objects of spec to the namespace of dstK8,
+// objects not selected by opts are left alone. on update the fields opts ignores are kept from the destination object,
+// and with a three-way merge the changes made in the destination since the last sync too.
+// an object with an unchanged source hash is only fetched when another field manager changed its destination object
+// since k8sync wrote it, and updated when that change drifted from the source.
+// only the names, source hashes and waves of destination objects are kept in memory
+func typedSyncer[T any, PT object[T]](spec kindSpec[T, PT], src source[T], waves func(context.Context) (map[int]bool, error),
+	dstK8 *k8client.K8s, opts *Options, rec Recorder) *kindSyncer {
+	kind, client := spec.kind, spec.client
+	update := spec.update
+	if update == nil {
+		update = func(ctx context.Context, obj PT, hash string) error {
+			_, err := client.Update(ctx, obj, metav1.UpdateOptions{FieldManager: fieldManager})
+			return err
+		}
+	}
+	// write updates the destination object of obj, keeping the fields rules ignore and with threeWay
+	// the destination changes, and tracks it for verification
+	write := func(ctx context.Context, obj PT, hash string, rules []IgnoreRule, threeWay bool) {
+		name := obj.GetName()
+		action, err := utils.EventTypeUpdate, error(nil)
+		v := opts.verifying(kind)
+		var previous PT
+		if v != nil {
+			previous, err = client.Get(ctx, name, metav1.GetOptions{})
+		}
+		switch {
+		case err != nil:
+		case len(rules) > 0 || threeWay:
+			// the merged object has the resource version of the destination, a destination changed
+			// after the merge is merged again
+			src := obj.DeepCopyObject().(PT)
+			var conflicts []string
+			err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				*obj = *src.DeepCopyObject().(PT)
+				var err error
+				if conflicts, err = mergeDestination(ctx, client, obj, rules, threeWay); err != nil {
+					return err
+				}
+				return update(ctx, obj, hash)
+			})
+			if err == nil && len(conflicts) > 0 {
+				logger.Warnf("  %s %s changed in the source and the destination, the source wins: %s",
+					kind, name, strings.Join(conflicts, ", "))
+				if cr, ok := rec.(ConflictRecorder); ok {
+					cr.RecordConflict(kind, name, conflicts)
+				}
+			}
+		default:
+			err = update(ctx, obj, hash)
+		}
+		if isImmutable(err) {
+			if immutablePolicy(kind) != ImmutableSkip {
+				action = utils.EventTypeRecreate
+			}
+			err = recreate(ctx, kind, client, obj, err)
+		}
+		if v != nil && err == nil {
+			v.track(kind, name, previous)
+		}
+		recordResult(rec, kind, name, action, err)
+	}
+	return &kindSyncer{
+		kind:  kind,
+		rec:   rec,
+		waves: waves,
+		dst: func(ctx context.Context) (map[string]dstObject, error) {
+			return dstObjects(ctx, dstK8, spec.resource, spec.fieldSelector, opts)
+		},
+		apply: func(ctx context.Context, wave int, st *kindState, pool *workers) error {
+			return src(ctx, func(t *T) error {
+				obj := PT(t)
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if !opts.selected(obj.GetName()) || objectWave(obj) != wave || !spec.prepare(obj) {
+					return nil
+				}
+				rules, threeWay := opts.ignoreRules(kind, obj), opts.threeWay(kind)
+				hash, err := stampHash(obj, rules)
+				if err != nil {
+					return err
+				}
+				if threeWay {
+					if err = stampSnapshot(obj); err != nil {
+						return err
+					}
+				}
+				name := obj.GetName()
+				d, ok := st.dst[name]
+				delete(st.dst, name)
+				switch {
+				case !ok:
+					st.drift.Add(1)
+					logger.Infof("  create %s: %s", kind, name)
+					return pool.run(ctx, func() {
+						_, err := client.Create(ctx, obj, metav1.CreateOptions{FieldManager: fieldManager})
+						if v := opts.verifying(kind); v != nil && err == nil {
+							v.track(kind, name, nil)
+						}
+						recordResult(rec, kind, name, utils.EventTypeCreate, err)
+					})
+				case d.hash != hash:
+					st.drift.Add(1)
+					logger.Infof("  update %s: %s", kind, name)
+					return pool.run(ctx, func() {
+						write(ctx, obj, hash, rules, threeWay)
+					})
+				case threeWay, !d.changed:
+					// with a three-way merge the destination changes are kept on purpose, they are not drift
+					st.unchanged.Add(1)
+					logger.Debugf("  %s unchanged: %s", kind, name)
+					return nil
+				}
+				return pool.run(ctx, func() {
+					dst, err := client.Get(ctx, name, metav1.GetOptions{})
+					var changed bool
+					if err == nil {
+						changed, err = drifted(obj, dst, rules)
+					}
+					switch {
+					case err != nil:
+						recordResult(rec, kind, name, utils.EventTypeUpdate, err)
+					case changed:
+						st.drift.Add(1)
+						logger.Infof("  update %s: %s, changed in the destination", kind, name)
+						write(ctx, obj, hash, rules, threeWay)
+					default:
+						st.unchanged.Add(1)
+						logger.Debugf("  %s unchanged: %s, the destination changes are not drift", kind, name)
+						acknowledge(ctx, client, dst)
+					}
+				})
+			})
+		},
+		delete: func(ctx context.Context, name string) error {
+			return client.Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}
+}
+
+// clearMeta drops the cluster owned fields of a source object
+func clearMeta(obj metav1.Object) {
+	obj.SetNamespace("")
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetManagedFields(nil)
+	obj.SetUID("")
+	obj.SetResourceVersion("")
+}
+
+// exportYaml writes the manifest of obj of kind, secret values are encrypted
+func exportYaml(ns, kind string, obj runtime.Object) {
+	path := "yaml/" + ns
+	err := os.MkdirAll(path, 0750)
+	if err != nil && !os.IsExist(err) {
+		logger.Fatal(err)
+	}
+	data, err := gitops.Manifest(obj)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err = os.WriteFile(path+"/"+obj.(metav1.Object).GetName()+"-"+kind+".yaml", data, 0640); err != nil {
+		logger.Fatal(err)
+	}
+}
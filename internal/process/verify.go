@@ -0,0 +1,249 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/internal/k8s/utils"
+	"k8sync/pkg/logger"
+)
+
+// verifyInterval is the poll interval of the rollout verification
+const verifyInterval = 2 * time.Second
+
+// Verify checks after a sync that the deployments written came up and the services written have ready endpoints
+type Verify struct {
+	Timeout  time.Duration // bound of the verification of a namespace
+	Rollback bool          // restore the pre-sync spec of the objects failing verification, delete those the sync created
+}
+
+// verification collects the deployments and services written by a sync
+type verification struct {
+	mu      sync.Mutex
+	written []written
+}
+
+type written struct {
+	kind     string
+	name     string
+	previous runtime.Object // destination object before the update, nil when created
+}
+
+// verifying returns the verification of the sync of kind, nil when kind is not verified
+func (o *Options) verifying(kind string) *verification {
+	if o == nil || o.verification == nil || kind != "deployment" && kind != "service" {
+		return nil
+	}
+	return o.verification
+}
+
+func (v *verification) track(kind, name string, previous runtime.Object) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.written = append(v.written, written{kind: kind, name: name, previous: previous})
+}
+
+// verify waits until the written objects are ready in the namespace of dstK8 or opts.Timeout passes,
+// the objects failed are recorded in rec with the verify action and rolled back with opts.Rollback.
+// verification and rollback are each bounded by opts.Timeout, not by the sync timeout of ctx
+func (v *verification) verify(ctx context.Context, dstK8 *k8client.K8s, opts *Verify, rec Recorder) {
+	v.mu.Lock()
+	all := append([]written(nil), v.written...)
+	v.mu.Unlock()
+	pending := append([]written(nil), all...)
+	if len(all) == 0 {
+		return
+	}
+	ctx, span := tracer.Start(ctx, "verify", trace.WithAttributes(
+		attribute.String("k8sync.dst.namespace", dstK8.GetNamespace()),
+		attribute.Int("k8sync.objects", len(all)),
+	))
+	defer span.End()
+	logger.Infof("verify %d objects in %s", len(all), dstK8.GetNamespace())
+
+	failed := make(map[written]error)
+	reasons := make(map[written]string)
+	pollCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout)
+	defer cancel()
+	_ = wait.PollUntilContextCancel(pollCtx, verifyInterval, true, func(ctx context.Context) (bool, error) {
+		remaining := pending[:0]
+		for _, w := range pending {
+			reason, err := checkReady(ctx, dstK8, w)
+			switch {
+			case err != nil:
+				failed[w] = err
+			case reason != "":
+				if ctx.Err() == nil {
+					reasons[w] = reason
+				}
+				remaining = append(remaining, w)
+			}
+		}
+		pending = remaining
+		return len(pending) == 0, nil
+	})
+	for _, w := range pending {
+		failed[w] = fmt.Errorf("not ready after %s: %s", opts.Timeout, reasons[w])
+	}
+	if len(failed) > 0 {
+		span.SetStatus(otelcodes.Error, fmt.Sprintf("%d objects failed verification", len(failed)))
+	}
+
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout)
+	defer cancel()
+	for _, w := range all {
+		err, ok := failed[w]
+		if !ok {
+			continue
+		}
+		recordResult(rec, w.kind, w.name, utils.EventTypeVerify, err)
+		if !opts.Rollback {
+			continue
+		}
+		logger.Infof("  rollback %s: %s", w.kind, w.name)
+		recordResult(rec, w.kind, w.name, utils.EventTypeRollback, rollback(rollbackCtx, dstK8, w))
+	}
+}
+
+// checkReady returns why the written object w is not ready yet, empty when it is,
+// an error when it will not become ready. failed requests other than not found are retried
+func checkReady(ctx context.Context, dstK8 *k8client.K8s, w written) (string, error) {
+	ns := dstK8.GetNamespace()
+	switch w.kind {
+	case "deployment":
+		d, err := dstK8.Clientset.AppsV1().Deployments(ns).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return requestFailed(err)
+		}
+		return deploymentReady(d)
+	case "service":
+		s, err := dstK8.Clientset.CoreV1().Services(ns).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return requestFailed(err)
+		}
+		if s.Spec.Type == corev1.ServiceTypeExternalName || len(s.Spec.Selector) == 0 {
+			return "", nil
+		}
+		slices, err := dstK8.Clientset.DiscoveryV1().EndpointSlices(ns).List(ctx, metav1.ListOptions{
+			LabelSelector: discoveryv1.LabelServiceName + "=" + w.name,
+		})
+		if err != nil {
+			return requestFailed(err)
+		}
+		for _, slice := range slices.Items {
+			for _, e := range slice.Endpoints {
+				if e.Conditions.Ready == nil || *e.Conditions.Ready {
+					return "", nil
+				}
+			}
+		}
+		return "no ready endpoints", nil
+	}
+	return "", nil
+}
+
+// requestFailed makes a failed request of checkReady the reason the object is not ready yet,
+// unless the object is gone
+func requestFailed(err error) (string, error) {
+	if apierrors.IsNotFound(err) {
+		return "", err
+	}
+	return "request failed: " + err.Error(), nil
+}
+
+// deploymentReady follows kubectl rollout status: the spec is observed, every replica is updated and available
+func deploymentReady(d *appsv1.Deployment) (string, error) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return "spec update not observed yet", nil
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return "", errors.New("progress deadline exceeded")
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	switch {
+	case d.Status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas), nil
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d old replicas pending termination", d.Status.Replicas-d.Status.UpdatedReplicas), nil
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas), nil
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentAvailable && c.Status != corev1.ConditionTrue {
+			return "not available: " + c.Message, nil
+		}
+	}
+	return "", nil
+}
+
+// rollback restores the metadata and spec the destination object w had before the sync,
+// its source hash is restored too so the next sync updates it again. an object created by the sync is deleted
+func rollback(ctx context.Context, dstK8 *k8client.K8s, w written) error {
+	ns := dstK8.GetNamespace()
+	switch w.kind {
+	case "deployment":
+		prev, _ := w.previous.(*appsv1.Deployment)
+		return restore(ctx, w.kind, w.name, dstK8.Clientset.AppsV1().Deployments(ns), prev, deployFilter,
+			func(d, prev *appsv1.Deployment) {
+				d.Labels, d.Annotations, d.Spec = prev.Labels, prev.Annotations, prev.Spec
+			})
+	case "service":
+		prev, _ := w.previous.(*corev1.Service)
+		return restore(ctx, w.kind, w.name, dstK8.Clientset.CoreV1().Services(ns), prev, serviceFilter,
+			func(s, prev *corev1.Service) {
+				s.Labels, s.Annotations, s.Spec = prev.Labels, prev.Annotations, prev.Spec
+			})
+	}
+	return fmt.Errorf("rollback of %s is not supported", w.kind)
+}
+
+// restore copies previous into the destination object name with copyTo, or deletes the object when previous is nil.
+// an update rejected for an immutable field recreates previous, without the fields filter drops,
+// by the sync.on-immutable policy of kind
+func restore[T any, PT object[T]](ctx context.Context, kind, name string, client objectClient[PT], previous PT,
+	filter func(PT), copyTo func(dst, prev PT)) error {
+	if previous == nil {
+		propagation := metav1.DeletePropagationBackground
+		err := client.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		copyTo(obj, previous)
+		_, err = client.Update(ctx, obj, metav1.UpdateOptions{FieldManager: fieldManager})
+		return err
+	})
+	if !isImmutable(err) {
+		return err
+	}
+	obj := previous.DeepCopyObject().(PT)
+	filter(obj)
+	return recreate(ctx, kind, client, obj, err)
+}
@@ -0,0 +1,263 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	k8client "k8sync/internal/k8s/client"
+	"k8sync/internal/k8s/utils"
+	"k8sync/internal/metrics"
+	"k8sync/pkg/logger"
+)
+
+// syncWaveAnnotation moves an object out of the default wave 0, lower waves are applied first
+const syncWaveAnnotation = "k8sync.io/sync-wave"
+
+// kindOrder is the built-in apply order of the kinds in a wave, kinds not listed go last.
+// kinds k8sync does not sync yet keep their place, so new kinds do not change the order
+var kindOrder = []string{
+	"namespace", "customresourcedefinition",
+	"serviceaccount", "role", "clusterrole", "rolebinding", "clusterrolebinding",
+	"configmap", "secret",
+	"persistentvolume", "persistentvolumeclaim",
+	"deployment", "statefulset", "daemonset", "job", "cronjob",
+	"service", "ingress",
+}
+
+// kindSyncer syncs the objects of one kind wave by wave
+type kindSyncer struct {
+	kind string
+	rec  Recorder
+	// waves returns the waves of the selected source objects
+	waves func(ctx context.Context) (map[int]bool, error)
+	// dst lists the selected destination objects
+	dst func(ctx context.Context) (map[string]dstObject, error)
+	// apply creates or updates the source objects of wave on pool, and removes them from st.dst
+	apply func(ctx context.Context, wave int, st *kindState, pool *workers) error
+	// delete deletes a destination object
+	delete func(ctx context.Context, name string) error
+}
+
+// kindState is the progress of a kindSyncer, it is only changed by the goroutine running syncWaves
+// except for the counts the workers add to
+type kindState struct {
+	dst       map[string]dstObject // destination objects not seen in source yet
+	waves     map[int]bool
+	drift     atomic.Int64
+	unchanged atomic.Int64
+	elapsed   time.Duration // time spent on the kind, its waves are interleaved with other kinds
+	err       error
+}
+
+// syncWaves syncs each kind of syncers in the order of wave, then kind, a group of objects of the same
+// wave and kind is finished before the next group starts. destination objects gone from the source
+// are deleted in the reverse order, a kind failed to list or apply deletes nothing.
+// objects are written by sync.workers workers, a failed object is recorded in rec and the others still sync
+func syncWaves(ctx context.Context, srcNs string, syncers ...*kindSyncer) error {
+	sort.SliceStable(syncers, func(i, j int) bool {
+		return kindRank(syncers[i].kind) < kindRank(syncers[j].kind)
+	})
+	var errs []error
+	fail := func(s *kindSyncer, st *kindState, err error) {
+		st.err = err
+		errs = append(errs, fmt.Errorf("sync %s in %s failed: %w", s.kind, srcNs, err))
+	}
+
+	states := make([]*kindState, len(syncers))
+	waves := make(map[int]bool)
+	for i, s := range syncers {
+		st := &kindState{}
+		states[i] = st
+		start := time.Now()
+		var err error
+		if st.waves, err = s.waves(ctx); err == nil {
+			st.dst, err = s.dst(ctx)
+		}
+		st.elapsed += time.Since(start)
+		if err != nil {
+			fail(s, st, err)
+			continue
+		}
+		for w := range st.waves {
+			waves[w] = true
+		}
+	}
+
+	pool := newWorkers()
+	defer pool.wait()
+	for _, wave := range sortedWaves(waves) {
+		for i, s := range syncers {
+			st := states[i]
+			if st.err != nil || !st.waves[wave] {
+				continue
+			}
+			logger.Infof("sync %s, wave %d", s.kind, wave)
+			start := time.Now()
+			err := tracedApply(ctx, s, srcNs, wave, st, pool)
+			pool.wait()
+			st.elapsed += time.Since(start)
+			if err != nil {
+				fail(s, st, err)
+			}
+			if ctx.Err() != nil {
+				return errors.Join(errs...)
+			}
+		}
+	}
+
+	/* delete destination objects gone from source */
+	waves = make(map[int]bool)
+	for _, st := range states {
+		if st.err != nil {
+			continue
+		}
+		for _, d := range st.dst {
+			waves[d.wave] = true
+		}
+	}
+	deleteWaves := sortedWaves(waves)
+	for w := len(deleteWaves) - 1; w >= 0; w-- {
+		for i := len(syncers) - 1; i >= 0; i-- {
+			s, st := syncers[i], states[i]
+			if st.err != nil {
+				continue
+			}
+			start := time.Now()
+			for name, d := range st.dst {
+				if d.wave != deleteWaves[w] {
+					continue
+				}
+				st.drift.Add(1)
+				logger.Infof("  delete %s: %s", s.kind, name)
+				err := pool.run(ctx, func() {
+					err := s.delete(ctx, name)
+					recordResult(s.rec, s.kind, name, utils.EventTypeDelete, err)
+				})
+				if err != nil {
+					pool.wait()
+					return errors.Join(append(errs, err)...)
+				}
+			}
+			pool.wait()
+			st.elapsed += time.Since(start)
+		}
+	}
+
+	for i, s := range syncers {
+		st := states[i]
+		metrics.ObserveReconcile(s.kind, st.elapsed)
+		metrics.Drift.WithLabelValues(srcNs, s.kind).Set(float64(st.drift.Load()))
+		if st.err == nil {
+			logger.Infof("sync %s done: %d changed, %d unchanged", s.kind, st.drift.Load(), st.unchanged.Load())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tracedApply applies one group in a span, so the api requests it makes are grouped
+func tracedApply(ctx context.Context, s *kindSyncer, srcNs string, wave int, st *kindState, pool *workers) error {
+	ctx, span := tracer.Start(ctx, "sync "+s.kind, trace.WithAttributes(
+		attribute.String("k8sync.kind", s.kind),
+		attribute.String("k8sync.src.namespace", srcNs),
+		attribute.Int("k8sync.wave", wave),
+	))
+	defer span.End()
+	err := s.apply(ctx, wave, st, pool)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return err
+}
+
+// kindSyncerFor returns the syncer of kind from the namespace of srcK8 to the namespace of dstK8
+func kindSyncerFor(kind string, srcK8 *k8client.K8s, dstK8 *k8client.K8s, opts *Options, rec Recorder) (*kindSyncer, error) {
+	ns := srcK8.GetNamespace()
+	switch kind {
+	case "deployment":
+		return deploymentSyncer(ns, pagedDeployments(srcK8), metadataWaves(srcK8, deploymentResource, "", opts), dstK8, opts, rec), nil
+	case "service":
+		return serviceSyncer(ns, pagedServices(srcK8), metadataWaves(srcK8, serviceResource, "", opts), dstK8, opts, rec), nil
+	case "secret":
+		return secretSyncer(ns, pagedSecrets(srcK8), metadataWaves(srcK8, secretResource, notTokenSecrets, opts), dstK8, opts, rec), nil
+	case "configmap":
+		return configMapSyncer(ns, pagedConfigMaps(srcK8), metadataWaves(srcK8, configMapResource, "metadata.name!="+rootCAConfigMap, opts), dstK8, opts, rec), nil
+	case "serviceaccount":
+		return serviceAccountSyncer(ns, pagedServiceAccounts(srcK8), metadataWaves(srcK8, accountResource, "metadata.name!="+defaultServiceAccount, opts), dstK8, opts, rec), nil
+	case "persistentvolumeclaim":
+		return claimSyncer(ns, pagedPersistentVolumeClaims(srcK8), metadataWaves(srcK8, claimResource, "", opts), dstK8, opts, rec), nil
+	}
+	return nil, fmt.Errorf("unsupported object kind: %s", kind)
+}
+
+// metadataWaves returns the waves of the objects of gvr selected by opts, from their metadata
+func metadataWaves(k8 *k8client.K8s, gvr schema.GroupVersionResource, fieldSelector string, opts *Options) func(context.Context) (map[int]bool, error) {
+	return func(ctx context.Context) (map[int]bool, error) {
+		waves := make(map[int]bool)
+		err := eachMetadata(ctx, k8, gvr, fieldSelector, func(m *metav1.PartialObjectMetadata) {
+			if opts.selected(m.Name) {
+				waves[objectWave(m)] = true
+			}
+		})
+		return waves, err
+	}
+}
+
+// sliceWaves returns the waves of the items selected by opts
+func sliceWaves[T any, PT interface {
+	*T
+	metav1.Object
+}](items []T, opts *Options) func(context.Context) (map[int]bool, error) {
+	return func(ctx context.Context) (map[int]bool, error) {
+		waves := make(map[int]bool)
+		for i := range items {
+			if obj := PT(&items[i]); opts.selected(obj.GetName()) {
+				waves[objectWave(obj)] = true
+			}
+		}
+		return waves, nil
+	}
+}
+
+// objectWave returns the wave of the sync-wave annotation of obj, 0 when it is not set or invalid
+func objectWave(obj metav1.Object) int {
+	v, ok := obj.GetAnnotations()[syncWaveAnnotation]
+	if !ok {
+		return 0
+	}
+	wave, err := strconv.Atoi(v)
+	if err != nil {
+		logger.Warnf("invalid %s annotation of %s: %q, use wave 0", syncWaveAnnotation, obj.GetName(), v)
+		return 0
+	}
+	return wave
+}
+
+func kindRank(kind string) int {
+	for i, k := range kindOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(kindOrder)
+}
+
+func sortedWaves(waves map[int]bool) []int {
+	sorted := make([]int, 0, len(waves))
+	for w := range waves {
+		sorted = append(sorted, w)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
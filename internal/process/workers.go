@@ -0,0 +1,45 @@
+package process
+
+import (
+	"context"
+	"sync"
+
+	"k8sync/internal/config"
+)
+
+// workers runs object operations on at most sync.workers goroutines
+type workers struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+func newWorkers() *workers {
+	n := config.Current().Sync.Workers
+	if n <= 0 {
+		n = 1
+	}
+	return &workers{sem: make(chan struct{}, n)}
+}
+
+// run runs fn on a free worker, it waits for one until ctx is done
+func (w *workers) run(ctx context.Context, fn func()) error {
+	select {
+	case w.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		fn()
+	}()
+	return nil
+}
+
+// wait waits for all running operations
+func (w *workers) wait() {
+	w.wg.Wait()
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"k8sync/internal/config"
+	"k8sync/pkg/logger"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+
+	defaultServiceName = "k8sync"
+)
+
+// Tracer returns a named tracer of the global provider
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer("k8sync/" + name)
+}
+
+// Initialize sets the global tracer provider configured by trace.*,
+// the returned function flushes and stops the exporter.
+// Nothing is traced when trace.enabled is false.
+func Initialize(ctx context.Context) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	settings := config.Current().Trace
+	if !settings.Enabled {
+		return noop, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, settings)
+	if err != nil {
+		return noop, err
+	}
+	name := settings.ServiceName
+	if name == "" {
+		name = defaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(name)))
+	if err != nil {
+		return noop, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	logger.Infof("tracing enabled, export to %s", settings.Exporter)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, settings config.TraceSettings) (sdktrace.SpanExporter, io.Closer, error) {
+	switch settings.Exporter {
+	case ExporterOTLP, "":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(settings.Endpoint)}
+		if settings.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		return exporter, nil, err
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, nil, err
+	case ExporterFile:
+		file := settings.File
+		if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+			return nil, nil, err
+		}
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	}
+	return nil, nil, fmt.Errorf("unknown trace exporter: %s", settings.Exporter)
+}
+
+// InstrumentTransport returns a rest.Config WrapTransport function
+// tracing the api server requests of cluster
+func InstrumentTransport(cluster string) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt,
+			otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+				return fmt.Sprintf("k8s %s %s %s", cluster, r.Method, r.URL.Path)
+			}),
+			otelhttp.WithSpanOptions(trace.WithAttributes(attribute.String("k8sync.cluster", cluster))),
+		)
+	}
+}
@@ -6,14 +6,18 @@ import (
 
 	"gopkg.in/natefinch/lumberjack.v2"
 
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zapgrpc"
+
+	"k8sync/internal/config"
 )
 
 var log *zap.SugaredLogger
 
+// level is shared by all cores so it can be changed at runtime
+var level = zap.NewAtomicLevel()
+
 var levelMap = map[string]zapcore.Level{
 	"debug":  zapcore.DebugLevel,
 	"info":   zapcore.InfoLevel,
@@ -28,43 +32,54 @@ func GetGrpcLogger() *zapgrpc.Logger {
 	return zapgrpc.NewLogger(log.Desugar())
 }
 
-// Initialize init after viper init
-func Initialize() {
+// Initialize creates the logger of the log settings
+func Initialize(settings config.LogSettings) {
 	var syncWriters []zapcore.WriteSyncer
 	var encoder zapcore.Encoder
-	level := getLoggerLevel(viper.GetString("log.level"))
+	level.SetLevel(getLoggerLevel(settings.Level))
 	fileConfig := &lumberjack.Logger{
-		Filename:   viper.GetString("log.path"),    // 日志文件名
-		MaxSize:    viper.GetInt("log.maxsize"),    // 日志文件大小
-		MaxAge:     viper.GetInt("log.maxAge"),     // 最长保存天数
-		MaxBackups: viper.GetInt("log.maxBackups"), // 最多备份几个
-		LocalTime:  viper.GetBool("log.localtime"), // 日志时间戳
-		Compress:   viper.GetBool("log.compress"),  // 是否压缩文件，使用gzip
+		Filename:   settings.Path,       // 日志文件名
+		MaxSize:    settings.MaxSize,    // 日志文件大小
+		MaxAge:     settings.MaxAge,     // 最长保存天数
+		MaxBackups: settings.MaxBackups, // 最多备份几个
+		LocalTime:  settings.LocalTime,  // 日志时间戳
+		Compress:   settings.Compress,   // 是否压缩文件，使用gzip
 	}
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 		enc.AppendString(t.Format("2006-01-02 15:04:05.000000"))
 	}
 	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-	if viper.GetBool("log.jsonFormat") {
+	if settings.JsonFormat {
 		encoder = zapcore.NewJSONEncoder(encoderConfig)
 	} else {
 		encoder = zapcore.NewConsoleEncoder(encoderConfig)
 	}
-	if viper.GetBool("log.consoleStdout") {
+	if settings.ConsoleStdout {
 		syncWriters = append(syncWriters, zapcore.AddSync(os.Stdout))
 	}
-	if viper.GetBool("log.fileStdout") {
+	if settings.FileStdout {
 		syncWriters = append(syncWriters, zapcore.AddSync(fileConfig))
 	}
 	core := zapcore.NewCore(
 		encoder,
 		zapcore.NewMultiWriteSyncer(syncWriters...),
-		zap.NewAtomicLevelAt(level))
+		level)
 	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 	log = logger.Sugar()
 }
 
+// SetLevel changes the level of the running logger
+func SetLevel(lvl string) {
+	level.SetLevel(getLoggerLevel(lvl))
+}
+
+// ValidLevel reports whether lvl is a known level name
+func ValidLevel(lvl string) bool {
+	_, ok := levelMap[lvl]
+	return ok
+}
+
 func getLoggerLevel(lvl string) zapcore.Level {
 	if level, ok := levelMap[lvl]; ok {
 		return level